@@ -0,0 +1,78 @@
+package bookfile
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Document is the extracted text of a single 'files' record
+type Document struct {
+	Format   string            `json:"format"`
+	Pages    []string          `json:"pages"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// Page returns the text of a 1-indexed page
+func (d *Document) Page(page int) (string, error) {
+	if page < 1 || page > len(d.Pages) {
+		return "", fmt.Errorf("page %d exceeds total pages (%d)", page, len(d.Pages))
+	}
+	return d.Pages[page-1], nil
+}
+
+// FindPrimaryFile returns the 'files' record flagged as the primary file of a book
+func FindPrimaryFile(app core.App, bookId string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter(
+		"files",
+		"book = {:bookId} && primaryFile = true",
+		map[string]any{"bookId": bookId},
+	)
+}
+
+// Path returns the location of the uploaded file on disk.
+// PocketBase stores files in: /pb_data/storage/{collectionId}/{recordId}/{filename}
+func Path(app core.App, record *core.Record) string {
+	return filepath.Join(app.DataDir(), "storage", record.Collection().Id, record.Id, record.GetString("filename"))
+}
+
+// DetectFormat works out the format of a 'files' record.
+// The hand-entered 'filetype' wins, otherwise we fall back to the file extension.
+func DetectFormat(record *core.Record) string {
+	filetype := strings.ToLower(strings.TrimSpace(record.GetString("filetype")))
+	switch {
+	case strings.Contains(filetype, "pdf"):
+		return "pdf"
+	case strings.Contains(filetype, "epub"):
+		return "epub"
+	}
+
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(record.GetString("filename")), "."))
+	if ext == "" {
+		return filetype
+	}
+	return ext
+}
+
+// extract parses the file from scratch, bypassing the cache
+func extract(path string, format string) (*Document, error) {
+	var pages []string
+	var metadata map[string]string
+	var err error
+
+	switch format {
+	case "pdf":
+		pages, metadata, err = extractPdfPages(path)
+	case "epub":
+		pages, metadata, err = extractEpubPages(path)
+	default:
+		return nil, fmt.Errorf("unsupported file format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Document{Format: format, Pages: pages, Metadata: metadata}, nil
+}
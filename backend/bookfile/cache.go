@@ -0,0 +1,186 @@
+package bookfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Bump this whenever the extraction output changes so stale caches get rebuilt
+const cacheVersion = 2
+
+// How many extracted documents we keep decoded in memory
+const memoryCacheSize = 8
+
+// cacheEntry is the on-disk representation of an extracted document
+type cacheEntry struct {
+	Version     int      `json:"version"`
+	Fingerprint string   `json:"fingerprint"`
+	Document    Document `json:"document"`
+}
+
+// document copies the cached document so callers can't change what others read
+func (entry *cacheEntry) document() *Document {
+	return &Document{
+		Format:   entry.Document.Format,
+		Pages:    slices.Clone(entry.Document.Pages),
+		Metadata: maps.Clone(entry.Document.Metadata),
+	}
+}
+
+var (
+	memoryMu    sync.Mutex
+	memoryCache = make(map[string]*cacheEntry)
+	memoryOrder []string
+
+	// One lock per file record so concurrent requests don't parse the same file twice
+	extractLocks sync.Map
+)
+
+// Load returns the extracted text of a 'files' record.
+// Results are cached in memory and under pb_data/text_cache, keyed by the record id
+// and invalidated whenever the stored file changes. Every call gets its own copy.
+func Load(app core.App, record *core.Record) (*Document, error) {
+	path := Path(app, record)
+
+	fingerprint, err := fileFingerprint(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry := memoryGet(record.Id, fingerprint); entry != nil {
+		return entry.document(), nil
+	}
+
+	lock, _ := extractLocks.LoadOrStore(record.Id, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Another request may have filled the cache while we were waiting
+	if entry := memoryGet(record.Id, fingerprint); entry != nil {
+		return entry.document(), nil
+	}
+
+	cachePath := cacheFilePath(app, record.Id)
+
+	if entry, err := readCacheFile(cachePath); err == nil && entry.Version == cacheVersion && entry.Fingerprint == fingerprint {
+		memoryPut(record.Id, entry)
+		return entry.document(), nil
+	}
+
+	doc, err := extract(path, DetectFormat(record))
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		Version:     cacheVersion,
+		Fingerprint: fingerprint,
+		Document:    *doc,
+	}
+
+	if err := writeCacheFile(cachePath, entry); err != nil {
+		// The cache is an optimisation, we can still serve the extracted text
+		log.Printf("[TextCache] Failed to write cache for file %s: %v", record.Id, err)
+	}
+	memoryPut(record.Id, entry)
+
+	return entry.document(), nil
+}
+
+// Invalidate drops every cached copy of a 'files' record
+func Invalidate(app core.App, recordId string) {
+	memoryMu.Lock()
+	delete(memoryCache, recordId)
+	for i, id := range memoryOrder {
+		if id == recordId {
+			memoryOrder = append(memoryOrder[:i], memoryOrder[i+1:]...)
+			break
+		}
+	}
+	memoryMu.Unlock()
+
+	if err := os.Remove(cacheFilePath(app, recordId)); err != nil && !os.IsNotExist(err) {
+		log.Printf("[TextCache] Failed to remove cache for file %s: %v", recordId, err)
+	}
+}
+
+// fileFingerprint identifies a specific version of a file on disk.
+// PocketBase gives replaced uploads a new name, the size and mtime catch everything else.
+func fileFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s:%d:%d", filepath.Base(path), info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func cacheFilePath(app core.App, recordId string) string {
+	return filepath.Join(app.DataDir(), "text_cache", recordId+".json")
+}
+
+func readCacheFile(path string) (*cacheEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// writeCacheFile writes through a temp file so readers never see a partial cache
+func writeCacheFile(path string, entry *cacheEntry) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func memoryGet(recordId string, fingerprint string) *cacheEntry {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	entry, ok := memoryCache[recordId]
+	if !ok || entry.Fingerprint != fingerprint {
+		return nil
+	}
+	return entry
+}
+
+func memoryPut(recordId string, entry *cacheEntry) {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	if _, ok := memoryCache[recordId]; !ok {
+		memoryOrder = append(memoryOrder, recordId)
+	}
+	memoryCache[recordId] = entry
+
+	// Evict the oldest documents once we're over budget
+	for len(memoryOrder) > memoryCacheSize {
+		oldest := memoryOrder[0]
+		memoryOrder = memoryOrder[1:]
+		delete(memoryCache, oldest)
+	}
+}
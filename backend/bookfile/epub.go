@@ -0,0 +1,248 @@
+package bookfile
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// EPUB pages are built by packing paragraphs into fixed-size chunks.
+// The budget is measured in runes so page numbers only depend on the
+// file contents and stay stable across requests.
+const epubPageRunes = 2000
+
+// Block-level elements we treat as paragraphs when flattening XHTML
+const epubBlockSelector = "p, h1, h2, h3, h4, h5, h6, li, blockquote, pre, dt, dd, figcaption"
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+type epubPackage struct {
+	Metadata struct {
+		Titles      []string `xml:"title"`
+		Creators    []string `xml:"creator"`
+		Language    string   `xml:"language"`
+		Publisher   string   `xml:"publisher"`
+		Date        string   `xml:"date"`
+		Identifier  string   `xml:"identifier"`
+		Description string   `xml:"description"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		Id        string `xml:"id,attr"`
+		Href      string `xml:"href,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		IdRef  string `xml:"idref,attr"`
+		Linear string `xml:"linear,attr"`
+	} `xml:"spine>itemref"`
+}
+
+// extractEpubPages reads every spine document of an EPUB and paginates the text
+func extractEpubPages(filePath string) ([]string, map[string]string, error) {
+	z, err := zip.OpenReader(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer z.Close()
+
+	files := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	// 1. Locate the package document through META-INF/container.xml
+	var container epubContainer
+	if err := readZipXML(files, "META-INF/container.xml", &container); err != nil {
+		return nil, nil, err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return nil, nil, fmt.Errorf("epub has no rootfile")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg epubPackage
+	if err := readZipXML(files, opfPath, &pkg); err != nil {
+		return nil, nil, err
+	}
+
+	// 2. Walk the spine in reading order
+	hrefs := make(map[string]string, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		hrefs[item.Id] = item.Href
+	}
+
+	var paragraphs []string
+	for _, ref := range pkg.Spine {
+		if ref.Linear == "no" {
+			continue
+		}
+
+		href, ok := hrefs[ref.IdRef]
+		if !ok {
+			continue
+		}
+
+		// Manifest hrefs are URL encoded and relative to the package document
+		if unescaped, err := url.PathUnescape(href); err == nil {
+			href = unescaped
+		}
+		docPath := path.Join(path.Dir(opfPath), href)
+
+		f, ok := files[docPath]
+		if !ok {
+			continue
+		}
+
+		docParagraphs, err := readEpubParagraphs(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s: %w", docPath, err)
+		}
+		paragraphs = append(paragraphs, docParagraphs...)
+	}
+
+	return paginateParagraphs(paragraphs, epubPageRunes), epubMetadata(pkg), nil
+}
+
+// epubMetadata flattens the Dublin Core metadata of the package document
+func epubMetadata(pkg epubPackage) map[string]string {
+	metadata := make(map[string]string)
+
+	set := func(key string, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			metadata[key] = value
+		}
+	}
+
+	if len(pkg.Metadata.Titles) > 0 {
+		set("title", pkg.Metadata.Titles[0])
+	}
+	set("author", strings.Join(pkg.Metadata.Creators, ", "))
+	set("language", pkg.Metadata.Language)
+	set("publisher", pkg.Metadata.Publisher)
+	set("date", pkg.Metadata.Date)
+	set("identifier", pkg.Metadata.Identifier)
+	set("description", pkg.Metadata.Description)
+
+	return metadata
+}
+
+// readZipXML decodes an XML file from the EPUB archive
+func readZipXML(files map[string]*zip.File, name string, dst any) error {
+	f, ok := files[name]
+	if !ok {
+		return fmt.Errorf("epub is missing %s", name)
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xml.NewDecoder(rc).Decode(dst)
+}
+
+// readEpubParagraphs flattens an XHTML content document into paragraphs
+func readEpubParagraphs(f *zip.File) ([]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(rc, 50<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var paragraphs []string
+	body := doc.Find("body")
+
+	body.Find(epubBlockSelector).Each(func(i int, s *goquery.Selection) {
+		// Containers (e.g. a <li> wrapping <p>s) are covered by their children
+		if s.Find(epubBlockSelector).Length() > 0 {
+			return
+		}
+
+		text := strings.Join(strings.Fields(s.Text()), " ")
+		if text != "" {
+			paragraphs = append(paragraphs, text)
+		}
+	})
+
+	// Some converters emit bare text inside <div>s, fall back to line breaks
+	if len(paragraphs) == 0 {
+		for _, line := range strings.Split(body.Text(), "\n") {
+			line = strings.Join(strings.Fields(line), " ")
+			if line != "" {
+				paragraphs = append(paragraphs, line)
+			}
+		}
+	}
+
+	return paragraphs, nil
+}
+
+// paginateParagraphs packs paragraphs into pages of roughly maxRunes characters
+func paginateParagraphs(paragraphs []string, maxRunes int) []string {
+	var pages []string
+	var current []string
+	currentLen := 0
+
+	flush := func() {
+		if len(current) > 0 {
+			pages = append(pages, strings.Join(current, "\n\n"))
+			current = nil
+			currentLen = 0
+		}
+	}
+
+	for _, paragraph := range paragraphs {
+		for _, chunk := range splitLongParagraph(paragraph, maxRunes) {
+			chunkLen := len([]rune(chunk))
+			if currentLen > 0 && currentLen+chunkLen > maxRunes {
+				flush()
+			}
+			current = append(current, chunk)
+			currentLen += chunkLen
+		}
+	}
+	flush()
+
+	return pages
+}
+
+// splitLongParagraph splits a paragraph that is longer than a page on word boundaries
+func splitLongParagraph(paragraph string, maxRunes int) []string {
+	if len([]rune(paragraph)) <= maxRunes {
+		return []string{paragraph}
+	}
+
+	var chunks []string
+	current := ""
+	for _, word := range strings.Fields(paragraph) {
+		if current != "" && len([]rune(current))+len([]rune(word))+1 > maxRunes {
+			chunks = append(chunks, current)
+			current = ""
+		}
+		if current != "" {
+			current += " "
+		}
+		current += word
+	}
+	if current != "" {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
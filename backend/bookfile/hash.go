@@ -0,0 +1,42 @@
+package bookfile
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+)
+
+// DocumentHash is the "partial MD5" KOReader identifies documents by when syncing progress.
+// It hashes 1 KB samples at offsets 0, 1 KB, 4 KB, 16 KB ... up to 1 GB, stopping
+// at the end of the file, so it is cheap to compute for large books.
+func DocumentHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	const step, size = 1024, 1024
+	h := md5.New()
+	buf := make([]byte, size)
+
+	for i := -1; i <= 10; i++ {
+		// KOReader computes lshift(1024, -2) with 32 bit arithmetic, which is 0
+		offset := int64(0)
+		if i >= 0 {
+			offset = int64(step) << (2 * i)
+		}
+
+		n, err := f.ReadAt(buf, offset)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				return "", err
+			}
+			break
+		}
+		h.Write(buf[:n])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
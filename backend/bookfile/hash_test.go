@@ -0,0 +1,52 @@
+package bookfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The expected hashes come from a line by line port of KOReader's
+// util.partialMD5 (frontend/util.lua), run over the same generated files.
+// They cover files ending before, on and between the sampled offsets.
+func TestDocumentHash(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		want string
+	}{
+		{"empty", 0, "d41d8cd98f00b204e9800998ecf8427e"},
+		{"shorter than a sample", 100, "53064c2dc94d201fa5009ece55a9028d"},
+		{"exactly one sample", 1024, "5121b74d11d0ad611a246b4137993844"},
+		{"ends inside the 4 KB sample", 5000, "e9b23960f33568cd50442e8f305853c3"},
+		{"several megabytes", 3<<20 + 17, "951d6ad62a386c5d5e49f4c898e5c6c2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			for i := range data {
+				data[i] = byte((i*31 + 7) % 251)
+			}
+
+			path := filepath.Join(t.TempDir(), "book.epub")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := DocumentHash(path)
+			if err != nil {
+				t.Fatalf("DocumentHash() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DocumentHash() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDocumentHashMissingFile(t *testing.T) {
+	if _, err := DocumentHash(filepath.Join(t.TempDir(), "missing.pdf")); err == nil {
+		t.Error("DocumentHash() of a missing file returned no error")
+	}
+}
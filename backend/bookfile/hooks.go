@@ -0,0 +1,144 @@
+package bookfile
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"os"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterFileHooks keeps 'files' records and their cached text in sync with the uploads.
+// New or replaced uploads are extracted in the background, which fills in the
+// file type, size, KOReader document hash, page count and metadata plus the
+// parent book's totalPages.
+func RegisterFileHooks(app core.App) {
+	app.OnRecordAfterCreateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		go processFile(app, e.Record.Id)
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		go processFile(app, e.Record.Id)
+		return e.Next()
+	})
+
+	app.OnRecordAfterDeleteSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		Invalidate(e.App, e.Record.Id)
+		return e.Next()
+	})
+}
+
+// processFile extracts an uploaded file and stores what we learned about it.
+// It is safe to run repeatedly: saving the record re-triggers the update hook,
+// but the second pass hits the text cache and finds nothing left to change.
+func processFile(app core.App, recordId string) {
+	// Runs outside any request, a panic here would take the whole server down
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("[Files] Processing file %s panicked: %v", recordId, recovered)
+		}
+	}()
+
+	record, err := app.FindRecordById("files", recordId)
+	if err != nil {
+		log.Printf("[Files] Could not load file %s: %v", recordId, err)
+		return
+	}
+
+	if record.GetString("filename") == "" {
+		return
+	}
+
+	info, err := os.Stat(Path(app, record))
+	if err != nil {
+		log.Printf("[Files] Could not stat upload for file %s: %v", recordId, err)
+		return
+	}
+
+	format := DetectFormat(record)
+	changed := setIfChanged(record, "filetype", format)
+	changed = setIfChanged(record, "filesize", formatFileSize(info.Size())) || changed
+
+	if hash, err := DocumentHash(Path(app, record)); err != nil {
+		log.Printf("[Files] Failed to hash file %s: %v", recordId, err)
+	} else {
+		changed = setIfChanged(record, "documentHash", hash) || changed
+	}
+
+	doc, err := Load(app, record)
+	if err != nil {
+		log.Printf("[Files] Failed to extract text from file %s: %v", recordId, err)
+	} else {
+		if record.GetInt("pageCount") != len(doc.Pages) {
+			record.Set("pageCount", len(doc.Pages))
+			changed = true
+		}
+
+		stored := map[string]string{}
+		_ = record.UnmarshalJSONField("metadata", &stored)
+		if !maps.Equal(stored, doc.Metadata) {
+			record.Set("metadata", doc.Metadata)
+			changed = true
+		}
+	}
+
+	if changed {
+		if err := app.Save(record); err != nil {
+			log.Printf("[Files] Failed to save file %s: %v", recordId, err)
+			return
+		}
+		log.Printf("[Files] Processed file %s (%s, %d pages)", recordId, format, record.GetInt("pageCount"))
+	}
+
+	if doc != nil && record.GetBool("primaryFile") {
+		updateBookPages(app, record.GetString("book"), len(doc.Pages))
+	}
+}
+
+// updateBookPages copies the page count of the primary file onto the book
+func updateBookPages(app core.App, bookId string, pages int) {
+	if bookId == "" || pages == 0 {
+		return
+	}
+
+	book, err := app.FindRecordById("books", bookId)
+	if err != nil {
+		log.Printf("[Files] Could not load book %s: %v", bookId, err)
+		return
+	}
+
+	if book.GetInt("totalPages") == pages {
+		return
+	}
+
+	book.Set("totalPages", pages)
+	if err := app.Save(book); err != nil {
+		log.Printf("[Files] Failed to update page count for book %s: %v", bookId, err)
+	}
+}
+
+func setIfChanged(record *core.Record, key string, value string) bool {
+	if record.GetString(key) == value {
+		return false
+	}
+	record.Set(key, value)
+	return true
+}
+
+// formatFileSize renders a byte count the way it was typed in by hand, e.g. "2.4 MB"
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,66 @@
+package bookfile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// extractPdfPages reads the PDF once and returns the text of every page.
+// Pages without content are kept as empty strings so indexes line up with page numbers.
+func extractPdfPages(path string) (pages []string, metadata map[string]string, err error) {
+	// The pdf package panics on malformed files instead of returning an error
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			pages, metadata, err = nil, nil, fmt.Errorf("malformed pdf: %v", recovered)
+		}
+	}()
+
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	totalPage := r.NumPage()
+	pages = make([]string, totalPage)
+
+	for i := 1; i <= totalPage; i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+
+		text, err := p.GetPlainText(nil)
+		if err != nil {
+			continue
+		}
+		pages[i-1] = strings.ReplaceAll(text, "\t", " ")
+	}
+
+	return pages, readPdfInfo(r), nil
+}
+
+// readPdfInfo returns the non-empty entries of the PDF document information dictionary
+func readPdfInfo(r *pdf.Reader) map[string]string {
+	metadata := make(map[string]string)
+
+	info := r.Trailer().Key("Info")
+	if info.IsNull() {
+		return metadata
+	}
+
+	for _, key := range info.Keys() {
+		value := info.Key(key)
+		if value.Kind() != pdf.String {
+			continue
+		}
+
+		if text := strings.TrimSpace(value.Text()); text != "" {
+			metadata[strings.ToLower(key)] = text
+		}
+	}
+
+	return metadata
+}
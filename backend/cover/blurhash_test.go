@@ -0,0 +1,56 @@
+package cover
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// gradientImage is a 32×48 image with a different colour in every pixel
+func gradientImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 48))
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 32; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 5), B: uint8((x + y) * 3), A: 255})
+		}
+	}
+	return img
+}
+
+// The expected hashes come from a port of the reference encoder
+// (github.com/woltapp/blurhash, C/encode.c) run over the same pixels.
+func TestBlurHash(t *testing.T) {
+	solid := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	for i := 0; i < len(solid.Pix); i += 4 {
+		copy(solid.Pix[i:], []uint8{200, 30, 60, 255})
+	}
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		xComponents int
+		yComponents int
+		want        string
+	}{
+		{"portrait components", gradientImage(), 3, 4, "TxH2Dz2lwyl?ajjugLfjfQnia|jt"},
+		{"landscape components", gradientImage(), 4, 3, "LxH2Dz2lwyX6l?ajjue:gLfjfQfj"},
+		{"average colour only", solid, 1, 1, "00M^#R"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := blurHash(tt.img, tt.xComponents, tt.yComponents); got != tt.want {
+				t.Errorf("blurHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlurHashIgnoresBoundsOrigin(t *testing.T) {
+	img := gradientImage()
+	shifted := &image.NRGBA{Pix: img.Pix, Stride: img.Stride, Rect: image.Rect(10, 20, 42, 68)}
+
+	if got, want := blurHash(shifted, 3, 4), blurHash(img, 3, 4); got != want {
+		t.Errorf("blurHash() of a shifted image = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+// Package cover downloads and checks book cover images.
+// Cover URLs come from members and metadata providers, so downloads are
+// bounded in time and size and may only reach public addresses.
+package cover
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+
+	// Decoders for the formats we accept
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// Limits on a cover download
+const (
+	MaxSize      = 10 << 20 // bytes
+	MaxPixels    = 40_000_000
+	maxRedirects = 3
+
+	dialTimeout     = 5 * time.Second
+	responseTimeout = 10 * time.Second
+	totalTimeout    = 20 * time.Second
+)
+
+var (
+	ErrInvalidURL = errors.New("cover url must be an absolute http(s) url")
+	ErrBlocked    = errors.New("cover url points to a private address")
+	ErrTooLarge   = errors.New("cover image is too large")
+	ErrNotImage   = errors.New("cover is not a jpeg, png, gif or webp image")
+)
+
+// Content types we accept, by what http.DetectContentType sniffs
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Image is a downloaded cover that decoded fine
+type Image struct {
+	Data        []byte
+	ContentType string
+	Ext         string // including the dot, matches ContentType
+	Width       int
+	Height      int
+}
+
+var client = newClient(isPublic)
+
+// Fetch downloads the image at rawURL
+func Fetch(ctx context.Context, rawURL string) (*Image, error) {
+	return fetch(ctx, client, rawURL)
+}
+
+func fetch(ctx context.Context, client *http.Client, rawURL string) (*Image, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrBlocked) {
+			return nil, ErrBlocked
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cover download failed: %s", resp.Status)
+	}
+	if resp.ContentLength > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	// One byte more than allowed tells us it's too large
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	return Check(data)
+}
+
+// Check makes sure data is an image we accept. It trusts neither the
+// Content-Type header nor a file name, only the bytes and the decoder.
+func Check(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := extensions[contentType]
+	if !ok {
+		return nil, ErrNotImage
+	}
+
+	// Look at the dimensions before decoding, a small file can claim a huge canvas
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrNotImage
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+		return nil, ErrNotImage
+	}
+
+	return &Image{
+		Data:        data,
+		ContentType: contentType,
+		Ext:         ext,
+		Width:       config.Width,
+		Height:      config.Height,
+	}, nil
+}
+
+// newClient creates a client that only connects to addresses that pass allowed.
+// The check runs on the resolved address of every connection, so neither DNS
+// names pointing inside nor redirects get around it.
+func newClient(allowed func(netip.Addr) bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return ErrBlocked
+			}
+			if !allowed(addrPort.Addr().Unmap()) {
+				return ErrBlocked
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: totalTimeout,
+		Transport: &http.Transport{
+			// No proxy, it would be the one connecting to the real address
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   dialTimeout,
+			ResponseHeaderTimeout: responseTimeout,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+}
+
+// Non-public ranges netip's helpers don't cover
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // shared address space (carrier-grade NAT)
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use IPv4/IPv6 translation
+}
+
+// isPublic reports whether addr is a globally routable unicast address
+func isPublic(addr netip.Addr) bool {
+	if !addr.IsValid() || !addr.IsGlobalUnicast() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
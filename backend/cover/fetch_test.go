@@ -0,0 +1,159 @@
+package cover
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngClaiming rewrites the size in a PNG header (and its checksum) without
+// adding any pixels, like a decompression bomb would
+func pngClaiming(t *testing.T, width uint32, height uint32) []byte {
+	t.Helper()
+	data := encodePNG(t, gradientImage())
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestCheck(t *testing.T) {
+	valid := encodePNG(t, gradientImage())
+
+	got, err := Check(valid)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got.ContentType != "image/png" || got.Ext != ".png" || got.Width != 32 || got.Height != 48 {
+		t.Errorf("Check() = %s %s %dx%d, want image/png .png 32x48", got.ContentType, got.Ext, got.Width, got.Height)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrNotImage},
+		{"html", []byte("<!DOCTYPE html><html><body>Not found</body></html>"), ErrNotImage},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`), ErrNotImage},
+		{"truncated png", valid[:len(valid)/2], ErrNotImage},
+		{"png header only", valid[:33], ErrNotImage},
+		{"huge canvas", pngClaiming(t, 8000, 8000), ErrTooLarge},
+		{"zero width", pngClaiming(t, 0, 48), ErrNotImage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Check(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	valid := encodePNG(t, gradientImage())
+	tooLarge := make([]byte, MaxSize+1)
+	copy(tooLarge, valid)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover.png":
+			w.Write(valid)
+		case "/large-declared":
+			w.Header().Set("Content-Length", strconv.Itoa(len(tooLarge)))
+			w.Write(tooLarge)
+		case "/large-streamed":
+			w.(http.Flusher).Flush() // chunked, no Content-Length
+			w.Write(tooLarge)
+		case "/page":
+			w.Write([]byte("<html>cover</html>"))
+		case "/redirect":
+			http.Redirect(w, r, "/cover.png", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// The test server is on loopback, which the real client refuses
+	loopback := newClient(func(addr netip.Addr) bool { return addr.IsLoopback() })
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{"image", server.URL + "/cover.png", nil},
+		{"redirect", server.URL + "/redirect", nil},
+		{"declared too large", server.URL + "/large-declared", ErrTooLarge},
+		{"streamed too large", server.URL + "/large-streamed", ErrTooLarge},
+		{"not an image", server.URL + "/page", ErrNotImage},
+		{"relative url", "/cover.png", ErrInvalidURL},
+		{"other scheme", "file:///etc/passwd", ErrInvalidURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := fetch(context.Background(), loopback, tt.url)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("fetch() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && !bytes.Equal(img.Data, valid) {
+				t.Errorf("fetch() returned %d bytes, want the %d of the image", len(img.Data), len(valid))
+			}
+		})
+	}
+
+	if _, err := fetch(context.Background(), loopback, server.URL+"/missing"); err == nil {
+		t.Error("fetch() of a 404 returned no error")
+	}
+	if _, err := Fetch(context.Background(), server.URL+"/cover.png"); !errors.Is(err, ErrBlocked) {
+		t.Errorf("Fetch() from loopback error = %v, want ErrBlocked", err)
+	}
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::6810:84e5", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"ff02::1", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPublic(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package cover
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/disintegration/imaging"
+)
+
+// Covers larger than this are scaled down, nobody looks at them bigger
+const maxDimension = 1600
+
+const jpegQuality = 85
+
+// Processed is a cover ready to be stored on a book
+type Processed struct {
+	Image
+	Color    string // dominant colour as "#rrggbb"
+	BlurHash string
+}
+
+// Process decodes a checked cover and re-encodes it as JPEG, or as PNG when
+// it has transparency. Re-encoding drops EXIF and any other metadata, the EXIF
+// orientation is applied first so phone photos stay upright. It also works out
+// the dominant colour and a BlurHash for the frontend's placeholder.
+func Process(img *Image) (*Processed, error) {
+	decoded, err := imaging.Decode(bytes.NewReader(img.Data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, ErrNotImage
+	}
+
+	bounds := decoded.Bounds()
+	if bounds.Dx() > maxDimension || bounds.Dy() > maxDimension {
+		decoded = imaging.Fit(decoded, maxDimension, maxDimension, imaging.Lanczos)
+		bounds = decoded.Bounds()
+	}
+
+	result := &Processed{
+		Image: Image{
+			Width:  bounds.Dx(),
+			Height: bounds.Dy(),
+		},
+	}
+
+	var buf bytes.Buffer
+	if isOpaque(decoded) {
+		err = jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: jpegQuality})
+		result.ContentType, result.Ext = "image/jpeg", ".jpg"
+	} else {
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, decoded)
+		result.ContentType, result.Ext = "image/png", ".png"
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode cover: %w", err)
+	}
+	result.Data = buf.Bytes()
+
+	// Colour and placeholder come from a small copy, flattened onto white
+	// the way transparent covers show up in the app
+	small := imaging.Fit(decoded, 64, 64, imaging.Box)
+	small = imaging.Overlay(imaging.New(small.Bounds().Dx(), small.Bounds().Dy(), color.White), small, image.Point{}, 1)
+
+	result.Color = dominantColor(small)
+
+	// Covers are portrait, give the vertical axis more detail
+	if result.Width > result.Height {
+		result.BlurHash = blurHash(small, 4, 3)
+	} else {
+		result.BlurHash = blurHash(small, 3, 4)
+	}
+
+	return result, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return true
+}
+
+// dominantColor buckets the pixels by their top 4 bits per channel and
+// returns the average colour of the fullest bucket
+func dominantColor(img *image.NRGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+
+	var best *bucket
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+		key := (r>>4)<<8 | (g>>4)<<4 | b>>4
+
+		bk, ok := buckets[key]
+		if !ok {
+			bk = &bucket{}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r += r
+		bk.g += g
+		bk.b += b
+
+		if best == nil || bk.count > best.count {
+			best = bk
+		}
+	}
+
+	if best == nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
@@ -0,0 +1,97 @@
+package cover
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func solidImage(width int, height int, c color.NRGBA) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return img
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name          string
+		img           image.Image
+		wantType      string
+		wantWidth     int
+		wantHeight    int
+		wantColor     string
+		wantHashShape string // first character, T for 3×4 components, L for 4×3
+		wantHashColor string // characters 3-6, the average colour
+	}{
+		{"small opaque cover", solidImage(300, 450, color.NRGBA{200, 30, 60, 255}), "image/jpeg", 300, 450, "#c81e3c", "T", "M^#R"},
+		{"scaled down to fit", solidImage(3200, 2000, color.NRGBA{10, 120, 240, 255}), "image/jpeg", 1600, 1000, "#0a78f0", "L", "1Gp]"},
+		{"transparent is flattened onto white", solidImage(100, 150, color.NRGBA{0, 0, 0, 0}), "image/png", 100, 150, "#ffffff", "T", "TSUA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked, err := Check(encodePNG(t, tt.img))
+			if err != nil {
+				t.Fatalf("Check() error = %v", err)
+			}
+
+			got, err := Process(checked)
+			if err != nil {
+				t.Fatalf("Process() error = %v", err)
+			}
+
+			if got.ContentType != tt.wantType || got.Width != tt.wantWidth || got.Height != tt.wantHeight {
+				t.Errorf("Process() = %s %dx%d, want %s %dx%d", got.ContentType, got.Width, got.Height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+			if got.Color != tt.wantColor {
+				t.Errorf("Process() colour = %s, want %s", got.Color, tt.wantColor)
+			}
+			if len(got.BlurHash) != 28 || got.BlurHash[:1] != tt.wantHashShape || got.BlurHash[2:6] != tt.wantHashColor {
+				t.Errorf("Process() BlurHash = %q, want 28 characters like %s?%s…", got.BlurHash, tt.wantHashShape, tt.wantHashColor)
+			}
+
+			// What we store must be a valid cover itself
+			again, err := Check(got.Data)
+			if err != nil {
+				t.Fatalf("Check() of the processed cover error = %v", err)
+			}
+			if again.ContentType != got.ContentType || again.Width != got.Width || again.Height != got.Height {
+				t.Errorf("processed cover decodes as %s %dx%d, want %s %dx%d", again.ContentType, again.Width, again.Height, got.ContentType, got.Width, got.Height)
+			}
+		})
+	}
+}
+
+func TestProcessDropsMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, solidImage(40, 60, color.NRGBA{90, 90, 90, 255}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// APP1 segment with a fake EXIF payload right after the start of image
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 52.52N 13.40E")...)
+	segment := append([]byte{0xff, 0xe1, 0, byte(len(exif) + 2)}, exif...)
+	data := append(append(append([]byte{}, buf.Bytes()[:2]...), segment...), buf.Bytes()[2:]...)
+
+	checked, err := Check(data)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	got, err := Process(checked)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if bytes.Contains(got.Data, []byte("GPS 52.52N")) {
+		t.Error("Process() kept the EXIF data")
+	}
+}
+
+func TestProcessRejectsGarbage(t *testing.T) {
+	if _, err := Process(&Image{Data: []byte("not an image")}); err != ErrNotImage {
+		t.Errorf("Process() error = %v, want ErrNotImage", err)
+	}
+}
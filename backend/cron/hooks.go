@@ -0,0 +1,63 @@
+package cron
+
+import (
+	"log"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RegisterNoteHooks processes notes as soon as they are created, and retries
+// notes that failed to match whenever a book gets a new primary file, either
+// uploaded fresh or replacing an old one
+func RegisterNoteHooks(app core.App) {
+	startNoteQueue(app)
+
+	app.OnRecordAfterCreateSuccess("notes").BindFunc(func(e *core.RecordEvent) error {
+		if isPending(e.Record) {
+			EnqueueNote(e.Record.Id)
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterCreateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetBool("primaryFile") {
+			requeueInBackground(app, e.Record.GetString("book"))
+		}
+		return e.Next()
+	})
+
+	app.OnRecordAfterUpdateSuccess("files").BindFunc(func(e *core.RecordEvent) error {
+		if isNewPrimaryFile(e.Record) {
+			requeueInBackground(app, e.Record.GetString("book"))
+		}
+		return e.Next()
+	})
+}
+
+// isNewPrimaryFile reports whether an update changed which file a book reads from
+func isNewPrimaryFile(record *core.Record) bool {
+	if !record.GetBool("primaryFile") {
+		return false
+	}
+
+	original := record.Original()
+
+	return !original.GetBool("primaryFile") ||
+		original.GetString("filename") != record.GetString("filename") ||
+		original.GetString("book") != record.GetString("book")
+}
+
+// requeueInBackground runs RequeueFailedNotes without holding up the upload.
+// PocketBase only recovers panics inside requests, so one here is logged
+// instead of taking the server down.
+func requeueInBackground(app core.App, bookId string) {
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Printf("[Cron] ❌ Requeueing notes for book %s panicked: %v", bookId, recovered)
+			}
+		}()
+
+		RequeueFailedNotes(app, bookId)
+	}()
+}
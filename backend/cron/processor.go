@@ -1,14 +1,40 @@
 package cron
 
 import (
+	"fmt"
 	"log"
-	"path/filepath"
-	"strings"
+	"sync"
+
+	"sheikahslate/bookfile"
+	"sheikahslate/matcher"
+	"sheikahslate/sessions"
 
-	"github.com/ledongthuc/pdf"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Processing states stored in the notes 'status' field
+const (
+	StatusPending  = "pending"
+	StatusMatched  = "matched"
+	StatusNotFound = "not_found"
+	StatusNoFile   = "no_file"
+	StatusError    = "error"
 )
 
+// Notes that keep failing with an error are given up on after this many attempts
+const maxAttempts = 5
+
+// One lock per book so the same notes are never matched twice at once
+var bookLocks sync.Map
+
+// pendingNotesFilter selects notes that are waiting for a page number.
+// Notes from before the status field existed are picked up through 'processed'
+// and the old page=999 "not found" sentinel so they get another chance.
+const pendingNotesFilter = `status = "pending" ||
+	(status = "error" && attempts < {:maxAttempts}) ||
+	(status = "" && (processed = false || page = 999))`
+
 // ProcessUnprocessedNotes handles the main cron job logic for processing notes
 func ProcessUnprocessedNotes(app core.App) {
 	log.Println("[Cron] 🔍 Checking for unprocessed notes...")
@@ -16,10 +42,11 @@ func ProcessUnprocessedNotes(app core.App) {
 	// 1. Fetch unprocessed notes
 	notes, err := app.FindRecordsByFilter(
 		"notes",
-		"processed = false",
+		pendingNotesFilter,
 		"-created",
 		0,
 		0,
+		map[string]any{"maxAttempts": maxAttempts},
 	)
 	if err != nil {
 		log.Printf("[Cron] ❌ Error fetching notes: %v", err)
@@ -44,85 +71,215 @@ func ProcessUnprocessedNotes(app core.App) {
 
 	// 3. Process each Book
 	for bookId, bookNotes := range notesByBook {
-		// A. Find the PDF file
-		fileRecord, err := app.FindFirstRecordByFilter(
-			"files",
-			"book = {:bookId} && primaryFile = true",
-			map[string]any{"bookId": bookId},
-		)
-		if err != nil {
-			log.Printf("[Cron] No PDF found for book %s. Skipping.", bookId)
-			continue
+		processBookNotes(app, bookId, bookNotes)
+	}
+}
+
+// processBookNotes finds the pages of a batch of notes that belong to the same book.
+// Books are processed one batch at a time so the cron sweep and the note queue
+// never work on the same notes at once; notes that were handled while we waited
+// for the lock are skipped.
+func processBookNotes(app core.App, bookId string, bookNotes []*core.Record) {
+	lock, _ := bookLocks.LoadOrStore(bookId, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// A panic in extraction or matching fails the notes that weren't saved yet,
+	// the queue worker or cron sweep carries on with the next book
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("[Cron] ❌ Processing notes for book %s panicked: %v", bookId, recovered)
+			markNotes(app, refreshPendingNotes(app, bookNotes), StatusError, fmt.Sprintf("Processing failed: %v", recovered))
 		}
+	}()
 
-		filePath := filepath.Join(app.DataDir(), "storage", fileRecord.Collection().Id, fileRecord.Id, fileRecord.GetString("filename"))
+	bookNotes = refreshPendingNotes(app, bookNotes)
+	if len(bookNotes) == 0 {
+		return
+	}
 
-		// B. LOAD ENTIRE BOOK INTO MEMORY
-		// Returns map[PageNumber]ContentString
-		bookContent, err := extractAllPages(filePath)
-		if err != nil {
-			log.Printf("[Cron] Failed to read PDF %s: %v", bookId, err)
-			continue
+	// A. Find the book file
+	fileRecord, err := bookfile.FindPrimaryFile(app, bookId)
+	if err != nil {
+		log.Printf("[Cron] No file found for book %s. Skipping.", bookId)
+		markNotes(app, bookNotes, StatusNoFile, "Book has no primary file")
+		return
+	}
+
+	// B. LOAD ENTIRE BOOK INTO MEMORY
+	// The text cache means we only parse the file once, not every run
+	doc, err := bookfile.Load(app, fileRecord)
+	if err != nil {
+		log.Printf("[Cron] Failed to read file for book %s: %v", bookId, err)
+		markNotes(app, bookNotes, StatusError, "Failed to read book file: "+err.Error())
+		return
+	}
+
+	// Normalize every page once, then search it for each note
+	book := matcher.NewBook(doc.Pages)
+
+	// Reading positions are looked up once per user
+	readingPages := make(map[string]int)
+
+	// C. Check all notes against the loaded book
+	for _, note := range bookNotes {
+		// Normalized exact matches first, then fuzzy word alignments, in page order
+		candidates := book.FindAll(note.GetString("bookText"))
+
+		// A quote that appears more than once goes to the copy nearest the reader
+		userId := note.GetString("user")
+		readingPage, ok := readingPages[userId]
+		if !ok {
+			readingPage = findReadingPage(app, bookId, userId)
+			readingPages[userId] = readingPage
 		}
 
-		// C. Check all notes against the loaded book map
-		for _, note := range bookNotes {
-			targetText := cleanTextForSearch(note.GetString("bookText"))
-			foundPage := 999
-
-			// Iterate through our in-memory book map
-			for pageNum, pageText := range bookContent {
-				if strings.Contains(pageText, targetText) {
-					foundPage = pageNum
-					break // Stop searching once found
-				}
-			}
-
-			// D. Update the record
-			note.Set("processed", true)
-			if foundPage < 999 {
-				note.Set("page", foundPage)
-				log.Printf("[Cron] MATCH: Note %s -> Page %d", note.Id, foundPage)
-			} else {
-				note.Set("page", foundPage)
-				log.Printf("[Cron] FAIL: Could not find text for note %s", note.Id)
-			}
-
-			if err := app.Save(note); err != nil {
-				log.Printf("[Cron] Database save failed for note %s: %v", note.Id, err)
-			}
+		// Imports that know the chapter narrow it down further
+		var chapterPages []int
+		if chapter := note.GetString("chapter"); chapter != "" && len(candidates) > 1 {
+			chapterPages = book.ChapterPages(chapter)
+		}
+		match, found := matcher.ChooseInChapter(candidates, chapterPages, readingPage)
+
+		// D. Update the record
+		if found {
+			SetNoteLocation(note, match, candidates)
+			setStatus(note, StatusMatched, "")
+			log.Printf("[Cron] MATCH: Note %s -> Pages %d-%d (score %.2f, %d candidates)", note.Id, match.Page, match.EndPage, match.Score, len(candidates))
+		} else {
+			note.Set("page", nil)
+			note.Set("endPage", nil)
+			note.Set("startOffset", nil)
+			note.Set("endOffset", nil)
+			note.Set("matchScore", 0)
+			note.Set("alternatives", nil)
+			setStatus(note, StatusNotFound, "Highlighted text was not found in the book file")
+			log.Printf("[Cron] FAIL: Could not find text for note %s", note.Id)
+		}
+
+		if err := app.Save(note); err != nil {
+			log.Printf("[Cron] Database save failed for note %s: %v", note.Id, err)
 		}
 	}
 }
 
-// extractAllPages reads the PDF once and returns a map of PageNum -> Text
-func extractAllPages(path string) (map[int]string, error) {
-	f, r, err := pdf.Open(path)
+// refreshPendingNotes reloads a batch of notes and drops the ones that are no longer pending
+func refreshPendingNotes(app core.App, notes []*core.Record) []*core.Record {
+	ids := make([]string, len(notes))
+	for i, note := range notes {
+		ids[i] = note.Id
+	}
+
+	fresh, err := app.FindRecordsByIds("notes", ids)
 	if err != nil {
-		return nil, err
+		log.Printf("[Cron] ❌ Error reloading notes: %v", err)
+		return nil
 	}
-	defer f.Close()
 
-	results := make(map[int]string)
-	totalPage := r.NumPage()
+	pending := fresh[:0]
+	for _, note := range fresh {
+		if isPending(note) {
+			pending = append(pending, note)
+		}
+	}
+	return pending
+}
+
+// isPending mirrors pendingNotesFilter for a single loaded note
+func isPending(note *core.Record) bool {
+	switch note.GetString("status") {
+	case StatusPending:
+		return true
+	case StatusError:
+		return note.GetInt("attempts") < maxAttempts
+	case "":
+		return !note.GetBool("processed") || note.GetInt("page") == 999
+	}
+	return false
+}
+
+// SetNoteLocation stores the chosen location of a note.
+// The other candidates are kept in 'alternatives' so the user can pick a different one.
+func SetNoteLocation(note *core.Record, match matcher.Match, candidates []matcher.Match) {
+	alternatives := make([]matcher.Match, 0, len(candidates))
+	for _, c := range candidates {
+		if c != match {
+			alternatives = append(alternatives, c)
+		}
+	}
+
+	note.Set("page", match.Page)
+	note.Set("endPage", match.EndPage)
+	note.Set("startOffset", match.StartOffset)
+	note.Set("endOffset", match.EndOffset)
+	note.Set("matchScore", match.Score)
+	note.Set("alternatives", alternatives)
+}
+
+// findReadingPage returns the user's current page in a book, or 0 if they have no session
+func findReadingPage(app core.App, bookId string, userId string) int {
+	if session := sessions.FindReaderSession(app, bookId, userId); session != nil {
+		return session.GetInt("currentPage")
+	}
+	return 0
+}
+
+// RequeueFailedNotes puts every note of a book that could not be matched back in the queue
+func RequeueFailedNotes(app core.App, bookId string) {
+	notes, err := app.FindRecordsByFilter(
+		"notes",
+		"book = {:bookId} && (status = {:notFound} || status = {:noFile} || status = {:error} || (status = '' && page = 999))",
+		"",
+		0,
+		0,
+		map[string]any{
+			"bookId":   bookId,
+			"notFound": StatusNotFound,
+			"noFile":   StatusNoFile,
+			"error":    StatusError,
+		},
+	)
+	if err != nil {
+		log.Printf("[Cron] ❌ Error fetching failed notes for book %s: %v", bookId, err)
+		return
+	}
+
+	for _, note := range notes {
+		note.Set("status", StatusPending)
+		note.Set("statusReason", "")
+		note.Set("attempts", 0)
+		note.Set("processed", false)
 
-	// Loop through every page and store it in the map
-	for i := 1; i <= totalPage; i++ {
-		p := r.Page(i)
-		if p.V.IsNull() {
+		if err := app.Save(note); err != nil {
+			log.Printf("[Cron] Database save failed for note %s: %v", note.Id, err)
 			continue
 		}
-		text, _ := p.GetPlainText(nil)
-		results[i] = cleanTextForSearch(text)
+		EnqueueNote(note.Id)
+	}
+
+	if len(notes) > 0 {
+		log.Printf("[Cron] 🔁 Requeued %d failed notes for book %s", len(notes), bookId)
+	}
+}
+
+// markNotes gives every note in the batch the same outcome
+func markNotes(app core.App, notes []*core.Record, status string, reason string) {
+	for _, note := range notes {
+		setStatus(note, status, reason)
+
+		if err := app.Save(note); err != nil {
+			log.Printf("[Cron] Database save failed for note %s: %v", note.Id, err)
+		}
 	}
-	return results, nil
 }
 
-// cleanTextForSearch standardizes text to increase match rate
-func cleanTextForSearch(text string) string {
-	text = strings.ReplaceAll(text, "\t", " ")
-	text = strings.ReplaceAll(text, "\n", " ")
-	text = strings.ReplaceAll(text, "\r", " ")
-	text = strings.ReplaceAll(text, "  ", " ") // collapse double spaces
-	return strings.TrimSpace(text)
+// setStatus records the outcome of a processing attempt on a note
+func setStatus(note *core.Record, status string, reason string) {
+	note.Set("status", status)
+	note.Set("statusReason", reason)
+	note.Set("attempts", note.GetInt("attempts")+1)
+	note.Set("lastAttempt", types.NowDateTime())
+
+	// Kept in sync for clients that still read the old flag
+	note.Set("processed", status != StatusPending)
 }
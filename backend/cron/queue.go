@@ -0,0 +1,84 @@
+package cron
+
+import (
+	"log"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// How many note ids can wait in memory, anything beyond that is left for the cron sweep
+const queueSize = 1000
+
+// How many batches are processed at the same time
+const queueWorkers = 2
+
+// Largest batch a worker pulls off the queue in one go.
+// Imports create many notes for the same book, batching lets them share one tokenized book.
+const queueBatchSize = 100
+
+var noteQueue chan string
+
+// startNoteQueue launches the workers that process queued notes
+func startNoteQueue(app core.App) {
+	noteQueue = make(chan string, queueSize)
+
+	for i := 0; i < queueWorkers; i++ {
+		go runNoteWorker(app)
+	}
+}
+
+// EnqueueNote schedules a note for processing without blocking.
+// It returns false when the queue is full, in which case the cron sweep picks the note up later.
+func EnqueueNote(noteId string) bool {
+	if noteQueue == nil {
+		return false
+	}
+
+	select {
+	case noteQueue <- noteId:
+		return true
+	default:
+		log.Printf("[Queue] Queue full, note %s left for the cron sweep", noteId)
+		return false
+	}
+}
+
+func runNoteWorker(app core.App) {
+	for noteId := range noteQueue {
+		batch := []string{noteId}
+
+		// Grab whatever else is already waiting
+	drain:
+		for len(batch) < queueBatchSize {
+			select {
+			case id := <-noteQueue:
+				batch = append(batch, id)
+			default:
+				break drain
+			}
+		}
+
+		processQueuedNotes(app, batch)
+	}
+}
+
+// processQueuedNotes loads a batch of queued notes and processes them book by book
+func processQueuedNotes(app core.App, noteIds []string) {
+	notes, err := app.FindRecordsByIds("notes", noteIds)
+	if err != nil {
+		log.Printf("[Queue] ❌ Error loading queued notes: %v", err)
+		return
+	}
+
+	notesByBook := make(map[string][]*core.Record)
+	for _, note := range notes {
+		bookId := note.GetString("book")
+		if bookId != "" {
+			notesByBook[bookId] = append(notesByBook[bookId], note)
+		}
+	}
+
+	for bookId, bookNotes := range notesByBook {
+		processBookNotes(app, bookId, bookNotes)
+	}
+}
@@ -9,6 +9,8 @@ import (
 // RegisterCronJobs sets up all cron jobs for the application
 func RegisterCronJobs(app core.App) {
 	// Use the built-in app cron scheduler - this will show up in the admin UI
+	// New notes are processed straight away by the note queue, this is the
+	// safety net for anything the queue dropped or that failed with an error
 	app.Cron().MustAdd("process_notes", "*/5 * * * *", func() {
 		log.Println("[Cron] Starting note processing...")
 		ProcessUnprocessedNotes(app)
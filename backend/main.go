@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"sheikahslate/bookfile"
 	"sheikahslate/cron"
 	"sheikahslate/routes"
 
@@ -17,11 +18,25 @@ func main() {
 
 	routes.RegisterInviteRoute(app)
 	routes.RegisterBookAdditionRoutes(app)
+	routes.RegisterBookMergeRoute(app)
+	routes.RegisterLibraryImportRoute(app)
 	routes.RegisterNotesRoute(app)
+	routes.RegisterEmailImportRoute(app)
+	routes.RegisterInboundAddressRoutes(app)
+	routes.RegisterKindleImportRoute(app)
+	routes.RegisterKoboImportRoute(app)
+	routes.RegisterBookNotesRoute(app)
+	routes.RegisterNotesExportRoute(app)
 	routes.RegisterPDFRoute(app)
+	routes.RegisterKosyncRoutes(app)
+	routes.RegisterOPDSRoute(app)
+
+	// Extract text and metadata from uploaded book files
+	bookfile.RegisterFileHooks(app)
 
 	// Register cron jobs
 	cron.RegisterCronJobs(app)
+	cron.RegisterNoteHooks(app)
 
 	log.Println("PocketBase backend starting...")
 	log.Println("Admin UI available at: http://0.0.0.0:8768/_/")
@@ -0,0 +1,338 @@
+package matcher
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// MinScore is the lowest confidence at which an approximate match is accepted
+const MinScore = 0.8
+
+// Quotes shorter than this must match exactly, fuzzy matching a couple of words is noise
+const minFuzzyWords = 4
+
+// Upper limit on the locations returned for one quote
+const maxCandidates = 20
+
+// Match is the location of a quote in a book.
+// A highlight may run over a page break, so it has both a start and an end page.
+type Match struct {
+	Page        int     `json:"page"`        // 1-indexed page the quote starts on
+	EndPage     int     `json:"endPage"`     // 1-indexed page the quote ends on
+	StartOffset int     `json:"startOffset"` // character offset of the first matched word in the start page text
+	EndOffset   int     `json:"endOffset"`   // character offset just past the last matched word in the end page text
+	Score       float64 `json:"score"`       // 1 for an exact match, down to MinScore for fuzzy ones
+}
+
+// Book is the normalized text of a book, prepared once and searched for many quotes.
+// All pages are tokenized into one stream so quotes can be found across page breaks.
+type Book struct {
+	pages      []string
+	tokens     []Token
+	tokenPage  []int // page index of every token
+	pageStart  []int // index of the first token of every page
+	joined     string
+	joinedPos  []int // byte position of every token in joined
+	pageCounts []map[string]int
+}
+
+// NewBook tokenizes every page of a book
+func NewBook(pages []string) *Book {
+	b := &Book{
+		pages:      pages,
+		pageStart:  make([]int, len(pages)+1),
+		pageCounts: make([]map[string]int, len(pages)),
+	}
+
+	var joined strings.Builder
+	joined.WriteString(" ")
+
+	for i, text := range pages {
+		b.pageStart[i] = len(b.tokens)
+		counts := make(map[string]int)
+
+		for _, t := range Tokenize(text) {
+			b.tokens = append(b.tokens, t)
+			b.tokenPage = append(b.tokenPage, i)
+			b.joinedPos = append(b.joinedPos, joined.Len())
+			joined.WriteString(t.Text)
+			joined.WriteString(" ")
+			counts[t.Text]++
+		}
+
+		b.pageCounts[i] = counts
+	}
+	b.pageStart[len(pages)] = len(b.tokens)
+	b.joined = joined.String()
+
+	return b
+}
+
+// Find returns the first location of a quote in the book, see FindAll
+func (b *Book) Find(quote string) (Match, bool) {
+	return Choose(b.FindAll(quote), 0)
+}
+
+// FindAll returns every location of a quote in page order.
+// Exact matches on the normalized text win; only when there are none do we
+// fall back to the closest word-level alignments within any two consecutive
+// pages that score at least MinScore.
+func (b *Book) FindAll(quote string) []Match {
+	needle := Tokenize(quote)
+	if len(needle) == 0 || len(b.tokens) == 0 {
+		return nil
+	}
+
+	// 1. Exact matches on normalized words, across the whole book at once
+	matches := b.findExact(needle)
+	if len(matches) > 0 || len(needle) < minFuzzyWords {
+		return matches
+	}
+
+	// 2. Approximate matches, allowing a few words to be missing, extra or garbled.
+	// Windows of two pages catch highlights that start at the bottom of a page.
+	lastEnd := -1
+	for i := range b.pages {
+		last := min(i+1, len(b.pages)-1)
+
+		// Every quote word absent from the window costs at least one edit,
+		// so skip windows that can't possibly reach MinScore
+		if upperBound(needle, b.pageCounts[i:last+1]) < MinScore {
+			continue
+		}
+
+		from, to := b.pageStart[i], b.pageStart[last+1]
+		start, end, score := align(needle, b.tokens[from:to])
+		if score < MinScore {
+			continue
+		}
+
+		// Overlapping windows find the same passage twice, keep the better alignment
+		if len(matches) > 0 && from+start < lastEnd {
+			if score > matches[len(matches)-1].Score {
+				matches[len(matches)-1] = b.match(from+start, from+end, score)
+				lastEnd = from + end
+			}
+			continue
+		}
+
+		matches = append(matches, b.match(from+start, from+end, score))
+		lastEnd = from + end
+		if len(matches) == maxCandidates {
+			break
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Page != matches[j].Page {
+			return matches[i].Page < matches[j].Page
+		}
+		return matches[i].StartOffset < matches[j].StartOffset
+	})
+
+	return matches
+}
+
+// ChapterPages returns the pages a chapter heading such as "Chapter One" appears on.
+// Headings are short, so only exact (normalized) occurrences count.
+func (b *Book) ChapterPages(chapter string) []int {
+	needle := Tokenize(chapter)
+	if len(needle) == 0 || len(b.tokens) == 0 {
+		return nil
+	}
+
+	var pages []int
+	for _, m := range b.findExact(needle) {
+		if len(pages) == 0 || pages[len(pages)-1] != m.Page {
+			pages = append(pages, m.Page)
+		}
+	}
+	return pages
+}
+
+// Choose picks one location out of the candidates returned by FindAll.
+// The best scoring candidates win, ties go to the one nearest the reader's
+// current page and then to the earliest one. A readingPage of 0 means the
+// position is unknown, which makes this the first occurrence.
+func Choose(matches []Match, readingPage int) (Match, bool) {
+	return ChooseInChapter(matches, nil, readingPage)
+}
+
+// ChooseInChapter is Choose with a hint about the quote's chapter.
+// chapterPages are the pages its heading appears on (see ChapterPages); among
+// the best scoring candidates the one closest after a heading wins before the
+// reading position is considered. The table of contents counts as a heading
+// too, but the real heading is always closer to the quote.
+func ChooseInChapter(matches []Match, chapterPages []int, readingPage int) (Match, bool) {
+	if len(matches) == 0 {
+		return Match{}, false
+	}
+
+	best := matches[0]
+	bestChapter := chapterDistance(best.Page, chapterPages)
+
+	for _, m := range matches[1:] {
+		chapter := chapterDistance(m.Page, chapterPages)
+
+		// Cases without continue make m the new best
+		switch {
+		case m.Score > best.Score:
+		case m.Score < best.Score:
+			continue
+		case chapter < bestChapter:
+		case chapter > bestChapter:
+			continue
+		case readingPage > 0 && distance(m.Page, readingPage) < distance(best.Page, readingPage):
+		default:
+			continue
+		}
+
+		best, bestChapter = m, chapter
+	}
+
+	return best, true
+}
+
+// chapterDistance is how many pages a match sits after the closest heading before it.
+// Without headings every page is equally likely.
+func chapterDistance(page int, chapterPages []int) int {
+	if len(chapterPages) == 0 {
+		return 0
+	}
+
+	closest := math.MaxInt
+	for _, start := range chapterPages {
+		if start <= page && page-start < closest {
+			closest = page - start
+		}
+	}
+	return closest
+}
+
+func distance(page int, readingPage int) int {
+	if page > readingPage {
+		return page - readingPage
+	}
+	return readingPage - page
+}
+
+// findExact returns the occurrences of the normalized words of needle, at most maxCandidates
+func (b *Book) findExact(needle []Token) []Match {
+	var matches []Match
+	padded := " " + joinTokens(needle) + " "
+	for from := 0; len(matches) < maxCandidates; {
+		pos := strings.Index(b.joined[from:], padded)
+		if pos < 0 {
+			break
+		}
+
+		// pos points at the space before the first word
+		first := sort.SearchInts(b.joinedPos, from+pos+1)
+		matches = append(matches, b.match(first, first+len(needle), 1))
+		from = b.joinedPos[first]
+	}
+	return matches
+}
+
+// match converts a token range [first, end) into page numbers and offsets
+func (b *Book) match(first int, end int, score float64) Match {
+	last := end - 1
+	startPage := b.tokenPage[first]
+	endPage := b.tokenPage[last]
+
+	return Match{
+		Page:        startPage + 1,
+		EndPage:     endPage + 1,
+		StartOffset: utf8.RuneCountInString(b.pages[startPage][:b.tokens[first].Start]),
+		EndOffset:   utf8.RuneCountInString(b.pages[endPage][:b.tokens[last].End]),
+		Score:       score,
+	}
+}
+
+// upperBound is the best score a window could reach given the words it contains
+func upperBound(needle []Token, pages []map[string]int) float64 {
+	used := make(map[string]int, len(needle))
+	found := 0
+	for _, t := range needle {
+		available := 0
+		for _, counts := range pages {
+			available += counts[t.Text]
+		}
+
+		if used[t.Text] < available {
+			used[t.Text]++
+			found++
+		}
+	}
+	return float64(found) / float64(len(needle))
+}
+
+// align finds the run of text tokens with the smallest word edit distance
+// to needle (Sellers' algorithm). It returns the token range [start, end)
+// of that run and the distance turned into a 0-1 score.
+func align(needle []Token, text []Token) (int, int, float64) {
+	if len(text) == 0 {
+		return 0, 0, 0
+	}
+
+	n := len(text)
+	prev := make([]int, n+1)
+	cur := make([]int, n+1)
+
+	// Where the alignment ending in each column started
+	prevStart := make([]int, n+1)
+	curStart := make([]int, n+1)
+
+	// The match may start anywhere, so the first row is free
+	for j := range prevStart {
+		prevStart[j] = j
+	}
+
+	for i := 1; i <= len(needle); i++ {
+		cur[0] = i
+		curStart[0] = 0
+
+		for j := 1; j <= n; j++ {
+			cost := 1
+			if needle[i-1].Text == text[j-1].Text {
+				cost = 0
+			}
+
+			// Substitute / match, then skip a quote word, then skip a text word
+			cur[j], curStart[j] = prev[j-1]+cost, prevStart[j-1]
+			if prev[j]+1 < cur[j] {
+				cur[j], curStart[j] = prev[j]+1, prevStart[j]
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j], curStart[j] = cur[j-1]+1, curStart[j-1]
+			}
+		}
+
+		prev, cur = cur, prev
+		prevStart, curStart = curStart, prevStart
+	}
+
+	bestEnd := 0
+	for j := 1; j <= n; j++ {
+		if prev[j] < prev[bestEnd] {
+			bestEnd = j
+		}
+	}
+
+	start := prevStart[bestEnd]
+	if start >= bestEnd {
+		return 0, 0, 0
+	}
+
+	return start, bestEnd, 1 - float64(prev[bestEnd])/float64(len(needle))
+}
+
+func joinTokens(tokens []Token) string {
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.Text
+	}
+	return strings.Join(words, " ")
+}
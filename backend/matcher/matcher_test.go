@@ -0,0 +1,133 @@
+package matcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testPages = []string{
+	"Chapter One\nIn a hole in the ground there lived a hobbit. Not a nasty, dirty, wet hole.",
+	"It had a perfectly round door like a porthole, painted green, with a shiny yellow brass knob in the exact middle. The door opened on to a tube-shaped hall like a tunnel",
+	"a very comfortable tunnel without smoke, with panelled walls. Chapter Two\nThe hobbit was a very well-to-do hobbit.",
+}
+
+func TestFindAll(t *testing.T) {
+	book := NewBook(testPages)
+
+	tests := []struct {
+		name  string
+		quote string
+		want  []Match
+	}{
+		{
+			name:  "exact",
+			quote: "there lived a hobbit",
+			want:  []Match{{Page: 1, EndPage: 1, StartOffset: 36, EndOffset: 56, Score: 1}},
+		},
+		{
+			name:  "exact after normalization",
+			quote: "“Not a NASTY, dirty—wet hole”",
+			want:  []Match{{Page: 1, EndPage: 1, StartOffset: 58, EndOffset: 86, Score: 1}},
+		},
+		{
+			name:  "every occurrence in page order",
+			quote: "hobbit",
+			want: []Match{
+				{Page: 1, EndPage: 1, StartOffset: 50, EndOffset: 56, Score: 1},
+				{Page: 3, EndPage: 3, StartOffset: 78, EndOffset: 84, Score: 1},
+				{Page: 3, EndPage: 3, StartOffset: 107, EndOffset: 113, Score: 1},
+			},
+		},
+		{
+			name:  "across a page break",
+			quote: "a tube-shaped hall like a tunnel, a very comfortable tunnel",
+			want:  []Match{{Page: 2, EndPage: 3, StartOffset: 136, EndOffset: 25, Score: 1}},
+		},
+		{
+			name:  "fuzzy with a garbled word",
+			quote: "with a shiny yelow brass knob in the exact middle",
+			want:  []Match{{Page: 2, EndPage: 2, StartOffset: 62, EndOffset: 112, Score: 0.9}},
+		},
+		{
+			name:  "fuzzy with a missing word",
+			quote: "painted green with a shiny brass knob in the exact middle",
+			want:  []Match{{Page: 2, EndPage: 2, StartOffset: 47, EndOffset: 112, Score: 1 - 1.0/11}},
+		},
+		{
+			name:  "short quotes are never fuzzy",
+			quote: "dirty wet hoel",
+			want:  nil,
+		},
+		{
+			name:  "no match",
+			quote: "one ring to rule them all and in the darkness bind them",
+			want:  nil,
+		},
+		{
+			name:  "empty quote",
+			quote: " … ",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := book.FindAll(tt.quote); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindAll(%q) = %+v, want %+v", tt.quote, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindAllEmptyBook(t *testing.T) {
+	if got := NewBook(nil).FindAll("anything at all here"); got != nil {
+		t.Errorf("FindAll on an empty book = %+v, want nil", got)
+	}
+}
+
+func TestChapterPages(t *testing.T) {
+	book := NewBook(testPages)
+
+	tests := []struct {
+		chapter string
+		want    []int
+	}{
+		{"Chapter One", []int{1}},
+		{"chapter two", []int{3}},
+		{"Chapter Three", nil},
+	}
+
+	for _, tt := range tests {
+		if got := book.ChapterPages(tt.chapter); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ChapterPages(%q) = %v, want %v", tt.chapter, got, tt.want)
+		}
+	}
+}
+
+func TestChoose(t *testing.T) {
+	matches := []Match{{Page: 3}, {Page: 10}, {Page: 40}}
+
+	tests := []struct {
+		name        string
+		readingPage int
+		want        int
+		ok          bool
+	}{
+		{"no reading page takes the first", 0, 3, true},
+		{"closest to the reading page", 12, 10, true},
+		{"past the last match", 100, 40, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Choose(matches, tt.readingPage)
+			if ok != tt.ok || got.Page != tt.want {
+				t.Errorf("Choose(%d) = page %d, %v, want page %d, %v", tt.readingPage, got.Page, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	if _, ok := Choose(nil, 5); ok {
+		t.Error("Choose(nil) found a match")
+	}
+}
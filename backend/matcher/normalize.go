@@ -0,0 +1,151 @@
+package matcher
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Token is a normalized word together with its byte range in the original text
+type Token struct {
+	Text  string
+	Start int
+	End   int
+}
+
+// Tokenize splits text into normalized words for matching.
+//
+// Normalization is deliberately aggressive so that a highlight copied out of
+// Apple Books lines up with text extracted from a PDF:
+//   - compatibility forms are decomposed (ﬁ -> fi, ﬂ -> fl, full-width letters)
+//   - accents and case are folded (Café -> cafe)
+//   - soft hyphens, zero-width characters and apostrophes inside words are dropped
+//   - hyphens join words, including line-break hyphenation ("extra-\nordinary")
+//   - every other punctuation mark (smart quotes, dashes, ellipses) separates words
+func Tokenize(text string) []Token {
+	var tokens []Token
+	var current strings.Builder
+	start := -1
+	end := 0
+	pendingHyphen := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, Token{Text: current.String(), Start: start, End: end})
+		}
+		current.Reset()
+		start = -1
+		pendingHyphen = false
+	}
+
+	for i, r := range text {
+		size := utf8.RuneLen(r)
+		if size < 0 {
+			size = 1
+		}
+
+		switch {
+		case isIgnorable(r):
+			continue
+
+		case isHyphen(r):
+			// Hyphens only join when they follow a word, "--" is a dash
+			if current.Len() > 0 && !pendingHyphen {
+				pendingHyphen = true
+				continue
+			}
+			flush()
+
+		case isApostrophe(r):
+			if current.Len() > 0 {
+				continue
+			}
+			flush()
+
+		case unicode.IsSpace(r):
+			// "extra- ordinary" is a word broken across lines, keep it open
+			if pendingHyphen {
+				continue
+			}
+			flush()
+
+		default:
+			folded := foldRune(r)
+			if folded == "" {
+				flush()
+				continue
+			}
+
+			if start < 0 {
+				start = i
+			}
+			current.WriteString(folded)
+			end = i + size
+			pendingHyphen = false
+		}
+	}
+	flush()
+
+	return tokens
+}
+
+// Normalize returns the normalized words of text joined by single spaces
+func Normalize(text string) string {
+	return joinTokens(Tokenize(text))
+}
+
+// foldRune decomposes a rune and keeps only its lower-cased letters and digits.
+// An empty result means the rune is punctuation and should end the current word.
+func foldRune(r rune) string {
+	if r < utf8.RuneSelf {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return string(unicode.ToLower(r))
+		}
+		return ""
+	}
+
+	var b strings.Builder
+	for _, d := range norm.NFKD.String(string(r)) {
+		switch {
+		case unicode.Is(unicode.Mn, d):
+			// Combining accents left over from the decomposition
+		case unicode.IsLetter(d) || unicode.IsDigit(d):
+			b.WriteRune(unicode.ToLower(d))
+		}
+	}
+	return b.String()
+}
+
+// isIgnorable reports characters that never affect matching
+func isIgnorable(r rune) bool {
+	switch r {
+	case '\u00ad', // soft hyphen
+		'\u200b', // zero width space
+		'\u200c', // zero width non-joiner
+		'\u200d', // zero width joiner
+		'\u2060', // word joiner
+		'\ufeff': // byte order mark
+		return true
+	}
+	return false
+}
+
+// isHyphen reports hyphens, which glue the parts of a word together
+func isHyphen(r rune) bool {
+	switch r {
+	case '-', '\u2010', '\u2011':
+		return true
+	}
+	return false
+}
+
+// isApostrophe reports apostrophes, which are dropped from inside words (don't -> dont)
+func isApostrophe(r rune) bool {
+	switch r {
+	case '\'', '\u2019', '\u02bc':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package matcher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"case and punctuation", "Fear is the mind-killer.", []string{"fear", "is", "the", "mindkiller"}},
+		{"accents", "Café crème, señor", []string{"cafe", "creme", "senor"}},
+		{"german", "Größe über Äpfel", []string{"große", "uber", "apfel"}},
+		{"ligatures", "ﬁnal ﬂight", []string{"final", "flight"}},
+		{"full width", "ＡＢＣ１２", []string{"abc12"}},
+		{"apostrophes", "don't won’t", []string{"dont", "wont"}},
+		{"smart quotes and dashes", "“Yes”—she said…", []string{"yes", "she", "said"}},
+		{"double hyphen is a dash", "wait--what", []string{"wait", "what"}},
+		{"line break hyphenation", "extra-\nordinary", []string{"extraordinary"}},
+		{"soft hyphen and zero width", "ex\u00adtra\u200bordinary", []string{"extraordinary"}},
+		{"byte order mark", "\ufeffHello", []string{"hello"}},
+		{"only punctuation", "… — !", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, token := range Tokenize(tt.text) {
+				got = append(got, token.Text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizeOffsets(t *testing.T) {
+	text := "Ein Café, bitte"
+	want := []Token{
+		{Text: "ein", Start: 0, End: 3},
+		{Text: "cafe", Start: 4, End: 9}, // é is two bytes
+		{Text: "bitte", Start: 11, End: 16},
+	}
+
+	if got := Tokenize(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize(%q) = %+v, want %+v", text, got, want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	if got, want := Normalize("  The  Hobbit:\nThere & Back Again "), "the hobbit there back again"; got != want {
+		t.Errorf("Normalize() = %q, want %q", got, want)
+	}
+}
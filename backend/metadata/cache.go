@@ -0,0 +1,64 @@
+package metadata
+
+import (
+	"sync"
+	"time"
+)
+
+// Upper limit on cached lookups, the oldest are dropped first
+const maxCacheEntries = 1000
+
+type cacheEntry struct {
+	book    *Book // nil for "not found"
+	expires time.Time
+}
+
+// cache remembers lookups for a while so adding a book doesn't hit the
+// providers again right after the client previewed it
+type cache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry
+	order   []string // insertion order, for eviction
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{ttl: ttl, entries: make(map[string]cacheEntry)}
+}
+
+// get returns a copy of the cached book; found is false for a cached "not found",
+// ok is false when there is nothing (fresh) cached
+func (c *cache) get(isbn13 string) (book *Book, found bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[isbn13]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false, false
+	}
+	if entry.book == nil {
+		return nil, false, true
+	}
+
+	copied := *entry.book
+	return &copied, true, true
+}
+
+func (c *cache) put(isbn13 string, book *Book) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.entries[isbn13]; !exists {
+		c.order = append(c.order, isbn13)
+	}
+	if book != nil {
+		copied := *book
+		book = &copied
+	}
+	c.entries[isbn13] = cacheEntry{book: book, expires: time.Now().Add(c.ttl)}
+
+	for len(c.order) > maxCacheEntries {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
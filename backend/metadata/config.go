@@ -0,0 +1,61 @@
+package metadata
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Environment variables configuring the chain built by FromEnv
+const (
+	providersEnv = "METADATA_PROVIDERS"   // comma separated, in order, e.g. "local,openlibrary,googlebooks"
+	localFileEnv = "METADATA_LOCAL_FILE"  // books file for the "local" provider
+	cacheTTLEnv  = "METADATA_CACHE_TTL"   // Go duration like "24h", "0" disables the cache
+	googleKeyEnv = "GOOGLE_BOOKS_API_KEY" // optional
+	defaultChain = "openlibrary,googlebooks"
+	defaultTTL   = 24 * time.Hour
+)
+
+// FromEnv builds the provider chain from METADATA_PROVIDERS, METADATA_LOCAL_FILE,
+// METADATA_CACHE_TTL and GOOGLE_BOOKS_API_KEY
+func FromEnv() (*Chain, error) {
+	names := os.Getenv(providersEnv)
+	if strings.TrimSpace(names) == "" {
+		names = defaultChain
+	}
+
+	var providers []Provider
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case "":
+		case "openlibrary":
+			providers = append(providers, OpenLibrary{})
+		case "googlebooks":
+			providers = append(providers, GoogleBooks{APIKey: os.Getenv(googleKeyEnv)})
+		case "local":
+			path := os.Getenv(localFileEnv)
+			if path == "" {
+				return nil, fmt.Errorf("the local metadata provider needs %s", localFileEnv)
+			}
+			local, err := NewLocal(path)
+			if err != nil {
+				return nil, fmt.Errorf("load local metadata: %w", err)
+			}
+			providers = append(providers, local)
+		default:
+			return nil, fmt.Errorf("unknown metadata provider %q in %s", name, providersEnv)
+		}
+	}
+
+	ttl := defaultTTL
+	if value := strings.TrimSpace(os.Getenv(cacheTTLEnv)); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", cacheTTLEnv, err)
+		}
+		ttl = parsed
+	}
+
+	return NewChain(ttl, providers...), nil
+}
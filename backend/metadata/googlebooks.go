@@ -0,0 +1,71 @@
+package metadata
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// GoogleBooks looks books up in the Google Books volumes API.
+// The key is optional, without one requests share Google's anonymous quota.
+type GoogleBooks struct {
+	APIKey string
+}
+
+func (GoogleBooks) Name() string { return "googlebooks" }
+
+type googleBooksResponse struct {
+	Items []struct {
+		VolumeInfo struct {
+			Title         string   `json:"title"`
+			Authors       []string `json:"authors"`
+			Publisher     string   `json:"publisher"`
+			PublishedDate string   `json:"publishedDate"`
+			Description   string   `json:"description"`
+			PageCount     int      `json:"pageCount"`
+			ImageLinks    struct {
+				SmallThumbnail string `json:"smallThumbnail"`
+				Thumbnail      string `json:"thumbnail"`
+			} `json:"imageLinks"`
+		} `json:"volumeInfo"`
+	} `json:"items"`
+}
+
+func (g GoogleBooks) Lookup(ctx context.Context, isbn13 string) (*Book, error) {
+	query := url.Values{}
+	query.Set("q", "isbn:"+isbn13)
+	if g.APIKey != "" {
+		query.Set("key", g.APIKey)
+	}
+
+	var response googleBooksResponse
+	if err := getJSON(ctx, "https://www.googleapis.com/books/v1/volumes?"+query.Encode(), &response); err != nil {
+		return nil, err
+	}
+	if len(response.Items) == 0 || response.Items[0].VolumeInfo.Title == "" {
+		return nil, ErrNotFound
+	}
+
+	info := response.Items[0].VolumeInfo
+	book := &Book{
+		Title:       strings.TrimSpace(info.Title),
+		Authors:     info.Authors,
+		Pages:       info.PageCount,
+		Publisher:   info.Publisher,
+		PublishDate: info.PublishedDate,
+		Description: strings.TrimSpace(info.Description),
+	}
+
+	cover := info.ImageLinks.Thumbnail
+	if cover == "" {
+		cover = info.ImageLinks.SmallThumbnail
+	}
+	if cover != "" {
+		// Google hands out http links and a page curl effect by default
+		cover = strings.Replace(cover, "http://", "https://", 1)
+		cover = strings.Replace(cover, "&edge=curl", "", 1)
+		book.CoverURL = cover
+	}
+
+	return book, nil
+}
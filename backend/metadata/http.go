@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Providers answer quickly or not at all, adding a book shouldn't hang on them
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// Open Library asks API clients to identify themselves
+const userAgent = "bookclub-backend (+https://github.com/Nawfay/bookclub-backend)"
+
+// Upper limit on a provider response, a lookup is a few KB
+const maxResponseSize = 2 << 20
+
+// Helper: GET a JSON document into v. A 404 is ErrNotFound.
+func getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
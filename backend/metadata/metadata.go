@@ -0,0 +1,248 @@
+// Package metadata looks up books by ISBN in a chain of metadata providers
+// (Open Library, Google Books, a local JSON file) with an in-memory cache.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when no provider knows the ISBN
+var ErrNotFound = errors.New("isbn not found")
+
+// ErrInvalidISBN is returned for anything that isn't a valid ISBN-10 or ISBN-13
+var ErrInvalidISBN = errors.New("invalid isbn")
+
+// Book is what the providers tell us about an edition
+type Book struct {
+	ISBN13      string   `json:"isbn13"`
+	ISBN10      string   `json:"isbn10,omitempty"`
+	Title       string   `json:"title"`
+	Authors     []string `json:"authors"`
+	Pages       int      `json:"pages,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	PublishDate string   `json:"publishDate,omitempty"` // as given by the provider, "2019", "March 2019", "2019-03-04"
+	Description string   `json:"description,omitempty"`
+	CoverURL    string   `json:"coverUrl,omitempty"`
+	Sources     []string `json:"sources"` // providers that contributed, in chain order
+}
+
+// Author joins the authors the way books.author stores them
+func (b *Book) Author() string {
+	return strings.Join(b.Authors, ", ")
+}
+
+// complete reports whether there is nothing left for later providers to fill in
+func (b *Book) complete() bool {
+	return b.Title != "" && len(b.Authors) > 0 && b.Pages > 0 && b.Publisher != "" &&
+		b.PublishDate != "" && b.Description != "" && b.CoverURL != ""
+}
+
+// fillFrom copies the fields b is missing from another provider's answer
+func (b *Book) fillFrom(other *Book, source string) {
+	filled := false
+	fill := func(dst *string, src string) {
+		if *dst == "" && src != "" {
+			*dst = src
+			filled = true
+		}
+	}
+
+	fill(&b.Title, other.Title)
+	fill(&b.Publisher, other.Publisher)
+	fill(&b.PublishDate, other.PublishDate)
+	fill(&b.Description, other.Description)
+	fill(&b.CoverURL, other.CoverURL)
+	if len(b.Authors) == 0 && len(other.Authors) > 0 {
+		b.Authors = other.Authors
+		filled = true
+	}
+	if b.Pages == 0 && other.Pages > 0 {
+		b.Pages = other.Pages
+		filled = true
+	}
+
+	if filled {
+		b.Sources = append(b.Sources, source)
+	}
+}
+
+// Provider is a source of book metadata
+type Provider interface {
+	// Name identifies the provider in Book.Sources and in METADATA_PROVIDERS
+	Name() string
+
+	// Lookup finds an edition by its ISBN-13. It returns ErrNotFound when the
+	// provider doesn't know the book and any other error when it couldn't tell.
+	Lookup(ctx context.Context, isbn13 string) (*Book, error)
+}
+
+// Chain asks its providers in order. The first one that knows the ISBN
+// provides the book, the following ones only fill in what it left empty.
+type Chain struct {
+	providers []Provider
+	cache     *cache // nil when caching is disabled
+}
+
+// NewChain creates a chain of providers. Answers, including "not found", are
+// cached for ttl; a ttl of 0 disables the cache.
+func NewChain(ttl time.Duration, providers ...Provider) *Chain {
+	c := &Chain{providers: providers}
+	if ttl > 0 {
+		c.cache = newCache(ttl)
+	}
+	return c
+}
+
+// Providers returns the names of the providers in chain order
+func (c *Chain) Providers() []string {
+	names := make([]string, len(c.providers))
+	for i, p := range c.providers {
+		names[i] = p.Name()
+	}
+	return names
+}
+
+// Lookup resolves an ISBN-10 or ISBN-13 (hyphens and spaces allowed)
+func (c *Chain) Lookup(ctx context.Context, isbn string) (*Book, error) {
+	isbn13, err := NormalizeISBN(isbn)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cache != nil {
+		if book, found, ok := c.cache.get(isbn13); ok {
+			if !found {
+				return nil, ErrNotFound
+			}
+			return book, nil
+		}
+	}
+
+	var result *Book
+	var lastErr error
+
+	for _, p := range c.providers {
+		book, err := p.Lookup(ctx, isbn13)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("[Metadata] %s lookup for %s failed: %v", p.Name(), isbn13, err)
+			lastErr = err
+			continue
+		}
+
+		if result == nil {
+			result = book
+			result.Sources = []string{p.Name()}
+		} else {
+			result.fillFrom(book, p.Name())
+		}
+		if result.complete() {
+			break
+		}
+	}
+
+	if result == nil {
+		// Don't remember failures, the provider may be back next time
+		if lastErr != nil {
+			return nil, lastErr
+		}
+		if c.cache != nil {
+			c.cache.put(isbn13, nil)
+		}
+		return nil, ErrNotFound
+	}
+
+	result.ISBN13 = isbn13
+	result.ISBN10 = ISBN10(isbn13)
+
+	if c.cache != nil {
+		c.cache.put(isbn13, result)
+	}
+	return result, nil
+}
+
+// NormalizeISBN validates an ISBN-10 or ISBN-13 and returns it as ISBN-13
+func NormalizeISBN(isbn string) (string, error) {
+	var digits []byte
+	for i := 0; i < len(isbn); i++ {
+		switch ch := isbn[i]; {
+		case ch >= '0' && ch <= '9':
+			digits = append(digits, ch)
+		case ch == 'X' || ch == 'x':
+			digits = append(digits, 'X')
+		case ch == '-' || ch == ' ':
+		default:
+			return "", ErrInvalidISBN
+		}
+	}
+
+	switch len(digits) {
+	case 10:
+		sum := 0
+		for i, ch := range digits {
+			value := int(ch - '0')
+			if ch == 'X' {
+				if i != 9 {
+					return "", ErrInvalidISBN
+				}
+				value = 10
+			}
+			sum += (10 - i) * value
+		}
+		if sum%11 != 0 {
+			return "", ErrInvalidISBN
+		}
+
+		isbn13 := "978" + string(digits[:9])
+		return isbn13 + string(isbn13CheckDigit(isbn13)), nil
+
+	case 13:
+		s := string(digits)
+		if strings.Contains(s, "X") || (!strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979")) {
+			return "", ErrInvalidISBN
+		}
+		if isbn13CheckDigit(s[:12]) != s[12] {
+			return "", ErrInvalidISBN
+		}
+		return s, nil
+	}
+
+	return "", ErrInvalidISBN
+}
+
+// ISBN10 converts an ISBN-13 to ISBN-10, empty for 979 numbers which have none
+func ISBN10(isbn13 string) string {
+	if len(isbn13) != 13 || !strings.HasPrefix(isbn13, "978") {
+		return ""
+	}
+
+	body := isbn13[3:12]
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(body[i]-'0')
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+	return body + string(rune('0'+check))
+}
+
+func isbn13CheckDigit(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(first12[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -0,0 +1,183 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		name    string
+		isbn    string
+		want    string
+		wantErr bool
+	}{
+		{"isbn-13", "9780306406157", "9780306406157", false},
+		{"isbn-13 with hyphens", "978-0-306-40615-7", "9780306406157", false},
+		{"979 prefix", "979-10-323-0569-0", "9791032305690", false},
+		{"isbn-10", "0306406152", "9780306406157", false},
+		{"isbn-10 with spaces", "0 306 40615 2", "9780306406157", false},
+		{"isbn-10 with X check digit", "080442957X", "9780804429573", false},
+		{"isbn-10 with lowercase x", "0-439-42089-x", "9780439420891", false},
+		{"wrong isbn-13 check digit", "9780306406158", "", true},
+		{"wrong isbn-10 check digit", "0306406153", "", true},
+		{"X before the check digit", "08044295X7", "", true},
+		{"X in an isbn-13", "978030640615X", "", true},
+		{"unknown isbn-13 prefix", "9770306406155", "", true},
+		{"too short", "030640615", "", true},
+		{"letters", "ISBN 0306406152", "", true},
+		{"empty", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NormalizeISBN(tt.isbn)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidISBN) {
+					t.Errorf("NormalizeISBN(%q) error = %v, want ErrInvalidISBN", tt.isbn, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("NormalizeISBN(%q) = %q, %v, want %q", tt.isbn, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestISBN10(t *testing.T) {
+	tests := []struct {
+		isbn13 string
+		want   string
+	}{
+		{"9780306406157", "0306406152"},
+		{"9780804429573", "080442957X"},
+		{"9780439420891", "043942089X"},
+		{"9791032305690", ""}, // 979 numbers have no ISBN-10
+		{"978030640615", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ISBN10(tt.isbn13); got != tt.want {
+			t.Errorf("ISBN10(%q) = %q, want %q", tt.isbn13, got, tt.want)
+		}
+	}
+}
+
+// fakeProvider answers from a map and counts the lookups it gets
+type fakeProvider struct {
+	name  string
+	books map[string]*Book
+	err   error
+	calls int
+}
+
+func (f *fakeProvider) Name() string { return f.name }
+
+func (f *fakeProvider) Lookup(ctx context.Context, isbn13 string) (*Book, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	book, ok := f.books[isbn13]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *book
+	return &copied, nil
+}
+
+func TestChainLookup(t *testing.T) {
+	const isbn13 = "9780306406157"
+
+	empty := &fakeProvider{name: "empty"}
+	partial := &fakeProvider{name: "partial", books: map[string]*Book{
+		isbn13: {Title: "Hobbit", Authors: []string{"J. R. R. Tolkien"}},
+	}}
+	details := &fakeProvider{name: "details", books: map[string]*Book{
+		isbn13: {Title: "The Hobbit", Pages: 310, Publisher: "Allen & Unwin"},
+	}}
+	chain := NewChain(0, empty, partial, details)
+
+	got, err := chain.Lookup(context.Background(), "0-306-40615-2")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+
+	want := &Book{
+		ISBN13:    isbn13,
+		ISBN10:    "0306406152",
+		Title:     "Hobbit",
+		Authors:   []string{"J. R. R. Tolkien"},
+		Pages:     310,
+		Publisher: "Allen & Unwin",
+		Sources:   []string{"partial", "details"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Lookup() = %+v, want %+v", got, want)
+	}
+	if empty.calls != 1 {
+		t.Errorf("empty provider asked %d times, want 1", empty.calls)
+	}
+}
+
+func TestChainLookupErrors(t *testing.T) {
+	ctx := context.Background()
+	unavailable := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		providers []Provider
+		isbn      string
+		want      error
+	}{
+		{"invalid isbn", []Provider{&fakeProvider{name: "a"}}, "123", ErrInvalidISBN},
+		{"nobody knows the book", []Provider{&fakeProvider{name: "a"}, &fakeProvider{name: "b"}}, "9780306406157", ErrNotFound},
+		{"a failing provider wins over not found", []Provider{&fakeProvider{name: "a", err: unavailable}, &fakeProvider{name: "b"}}, "9780306406157", unavailable},
+		{"no providers", nil, "9780306406157", ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewChain(0, tt.providers...).Lookup(ctx, tt.isbn); !errors.Is(err, tt.want) {
+				t.Errorf("Lookup() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainCache(t *testing.T) {
+	ctx := context.Background()
+	provider := &fakeProvider{name: "a", books: map[string]*Book{
+		"9780306406157": {Title: "The Hobbit"},
+	}}
+	chain := NewChain(time.Hour, provider)
+
+	first, err := chain.Lookup(ctx, "9780306406157")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	first.Title = "changed by the caller"
+
+	second, err := chain.Lookup(ctx, "0306406152")
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if second.Title != "The Hobbit" {
+		t.Errorf("cached title = %q, want the provider's", second.Title)
+	}
+
+	// Not found is remembered too
+	for i := 0; i < 2; i++ {
+		if _, err := chain.Lookup(ctx, "9780804429573"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Lookup() error = %v, want ErrNotFound", err)
+		}
+	}
+
+	if provider.calls != 2 {
+		t.Errorf("provider asked %d times, want 2", provider.calls)
+	}
+}
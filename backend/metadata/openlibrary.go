@@ -0,0 +1,89 @@
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OpenLibrary looks books up in the Open Library books API, no key needed
+type OpenLibrary struct{}
+
+func (OpenLibrary) Name() string { return "openlibrary" }
+
+type openLibraryEntry struct {
+	Details struct {
+		Title   string `json:"title"`
+		Authors []struct {
+			Name string `json:"name"`
+		} `json:"authors"`
+		NumberOfPages int             `json:"number_of_pages"`
+		Publishers    []string        `json:"publishers"`
+		PublishDate   string          `json:"publish_date"`
+		Description   json.RawMessage `json:"description"`
+		Covers        []int           `json:"covers"`
+	} `json:"details"`
+}
+
+func (OpenLibrary) Lookup(ctx context.Context, isbn13 string) (*Book, error) {
+	key := "ISBN:" + isbn13
+	endpoint := "https://openlibrary.org/api/books?format=json&jscmd=details&bibkeys=" + url.QueryEscape(key)
+
+	// Unknown ISBNs come back as an empty object rather than a 404
+	var response map[string]openLibraryEntry
+	if err := getJSON(ctx, endpoint, &response); err != nil {
+		return nil, err
+	}
+	entry, ok := response[key]
+	if !ok || entry.Details.Title == "" {
+		return nil, ErrNotFound
+	}
+
+	details := entry.Details
+	book := &Book{
+		Title:       strings.TrimSpace(details.Title),
+		Pages:       details.NumberOfPages,
+		PublishDate: details.PublishDate,
+		Description: openLibraryText(details.Description),
+	}
+	for _, author := range details.Authors {
+		if name := strings.TrimSpace(author.Name); name != "" {
+			book.Authors = append(book.Authors, name)
+		}
+	}
+	if len(details.Publishers) > 0 {
+		book.Publisher = details.Publishers[0]
+	}
+
+	// -1 marks a removed cover
+	for _, id := range details.Covers {
+		if id > 0 {
+			book.CoverURL = fmt.Sprintf("https://covers.openlibrary.org/b/id/%d-L.jpg", id)
+			break
+		}
+	}
+
+	return book, nil
+}
+
+// Helper: Open Library text fields are either a string or {"type": "/type/text", "value": "..."}
+func openLibraryText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return strings.TrimSpace(text)
+	}
+
+	var typed struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal(raw, &typed); err == nil {
+		return strings.TrimSpace(typed.Value)
+	}
+	return ""
+}
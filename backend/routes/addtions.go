@@ -1,16 +1,29 @@
 package routes
 
 import (
+	"errors"
 	"io"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 
+	"sheikahslate/cover"
+	"sheikahslate/metadata"
+
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/filesystem"
 )
 
 func RegisterBookAdditionRoutes(app core.App) {
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		// Providers and cache for the ISBN lookups, see metadata.FromEnv.
+		// A broken configuration only takes the ISBN routes down, they answer 503 until it's fixed.
+		lookup, lookupErr := metadata.FromEnv()
+		if lookupErr != nil {
+			se.App.Logger().Error("Invalid metadata configuration, ISBN lookups are disabled", "error", lookupErr.Error())
+		}
 
 		// POST /books/add/api - Add a new book with cover image download from URL
 		se.Router.POST("/books/add/api", func(e *core.RequestEvent) error {
@@ -20,6 +33,7 @@ func RegisterBookAdditionRoutes(app core.App) {
 				Author   string `json:"author"`
 				Pages    int    `json:"pages"`
 				CoverUrl string `json:"coverUrl"`
+				ISBN     string `json:"isbn"`
 			}{}
 
 			// 2. Parse the body
@@ -27,6 +41,18 @@ func RegisterBookAdditionRoutes(app core.App) {
 				return e.BadRequestError("Invalid data format", err)
 			}
 
+			isbn, err := optionalISBN(data.ISBN)
+			if err != nil {
+				return e.BadRequestError("Invalid ISBN", err)
+			}
+
+			// Refuse likely duplicates unless the caller insists with ?force=true
+			if existing, err := findUnforcedDuplicate(e, data.Title, data.Author, isbn); err != nil {
+				return e.InternalServerError("Failed to check for duplicate books", err)
+			} else if existing != nil {
+				return duplicateBookResponse(e, existing)
+			}
+
 			// 3. Find the 'books' collection
 			collection, err := app.FindCollectionByNameOrId("books")
 			if err != nil {
@@ -39,31 +65,16 @@ func RegisterBookAdditionRoutes(app core.App) {
 			record.Set("author", data.Author)
 			record.Set("totalPages", data.Pages)
 			record.Set("coverImageUrl", data.CoverUrl) // Save the URL string just in case
+			record.Set("isbn", isbn)
 			record.Set("status", "planned")
 
-			println(data.CoverUrl)
-			
 			if data.CoverUrl == "" {
 				data.CoverUrl = "https://openlibrary.org/images/icons/avatar_book-sm.png"
 			}
 
 			// 5. Download and attach the cover image (if URL exists)
 			if data.CoverUrl != "" {
-				resp, err := http.Get(data.CoverUrl)
-				if err == nil && resp.StatusCode == http.StatusOK {
-					defer resp.Body.Close()
-
-					// Read the image bytes into memory
-					imgBytes, err := io.ReadAll(resp.Body)
-					if err == nil {
-						// Create a PocketBase file from the bytes
-						// We name it "cover.jpg", PB will auto-generate a unique suffix if needed
-						f, err := filesystem.NewFileFromBytes(imgBytes, "cover.jpg")
-						if err == nil {
-							record.Set("cover", f)
-						}
-					}
-				}
+				downloadCover(e, record, data.CoverUrl)
 			}
 
 			// 6. Save the record to the database
@@ -92,6 +103,18 @@ func RegisterBookAdditionRoutes(app core.App) {
 				return e.BadRequestError("Title and Author are required", nil)
 			}
 
+			isbn, err := optionalISBN(e.Request.FormValue("isbn"))
+			if err != nil {
+				return e.BadRequestError("Invalid ISBN", err)
+			}
+
+			// Refuse likely duplicates unless the caller insists with ?force=true
+			if existing, err := findUnforcedDuplicate(e, title, author, isbn); err != nil {
+				return e.InternalServerError("Failed to check for duplicate books", err)
+			} else if existing != nil {
+				return duplicateBookResponse(e, existing)
+			}
+
 			// Parse pages
 			pages := 0
 			if pagesStr != "" {
@@ -112,6 +135,7 @@ func RegisterBookAdditionRoutes(app core.App) {
 			record.Set("author", author)
 			record.Set("totalPages", pages)
 			record.Set("coverImageUrl", coverUrl)
+			record.Set("isbn", isbn)
 			record.Set("status", "planned")
 
 			// Handle file upload (cover image)
@@ -120,34 +144,26 @@ func RegisterBookAdditionRoutes(app core.App) {
 				defer file.Close()
 
 				// Read the uploaded file
-				fileBytes, err := io.ReadAll(file)
+				fileBytes, err := io.ReadAll(io.LimitReader(file, cover.MaxSize+1))
 				if err == nil && len(fileBytes) > 0 {
-					// Create a PocketBase file from the uploaded bytes
-					// Use the original filename or default to cover.jpg
-					filename := fileHeader.Filename
-					if filename == "" {
-						filename = "cover.jpg"
+					// Same checks as for downloaded covers, whatever the file is called
+					if len(fileBytes) > cover.MaxSize {
+						return e.BadRequestError("Invalid cover image", cover.ErrTooLarge)
+					}
+					img, err := cover.Check(fileBytes)
+					if err != nil {
+						return e.BadRequestError("Invalid cover image", err)
 					}
 
-					f, err := filesystem.NewFileFromBytes(fileBytes, filename)
-					if err == nil {
-						record.Set("cover", f)
+					// Keep the original filename, setCover gives it the right extension
+					filename := strings.TrimSuffix(fileHeader.Filename, filepath.Ext(fileHeader.Filename))
+					if err := setCover(record, img, filename); err != nil {
+						return e.BadRequestError("Invalid cover image", err)
 					}
 				}
 			} else if coverUrl != "" {
 				// Fallback: try to download from URL if no file was uploaded
-				resp, err := http.Get(coverUrl)
-				if err == nil && resp.StatusCode == http.StatusOK {
-					defer resp.Body.Close()
-
-					imgBytes, err := io.ReadAll(resp.Body)
-					if err == nil && len(imgBytes) > 0 {
-						f, err := filesystem.NewFileFromBytes(imgBytes, "cover.jpg")
-						if err == nil {
-							record.Set("cover", f)
-						}
-					}
-				}
+				downloadCover(e, record, coverUrl)
 			}
 
 			// Save to database
@@ -158,6 +174,163 @@ func RegisterBookAdditionRoutes(app core.App) {
 			return e.JSON(http.StatusOK, record)
 		})
 
+		// GET /books/lookup/isbn/{isbn} - Preview what /books/add/isbn would add
+		se.Router.GET("/books/lookup/isbn/{isbn}", func(e *core.RequestEvent) error {
+			if lookupErr != nil {
+				return metadataUnavailable(e, lookupErr)
+			}
+
+			book, err := lookup.Lookup(e.Request.Context(), e.Request.PathValue("isbn"))
+			if err != nil {
+				return isbnLookupError(e, err)
+			}
+
+			return e.JSON(http.StatusOK, book)
+		}).Bind(apis.RequireAuth())
+
+		// POST /books/add/isbn - Add a new book from its ISBN-10 or ISBN-13
+		se.Router.POST("/books/add/isbn", func(e *core.RequestEvent) error {
+			if lookupErr != nil {
+				return metadataUnavailable(e, lookupErr)
+			}
+
+			// 1. Parse the body
+			data := struct {
+				ISBN string `json:"isbn"`
+			}{}
+			if err := e.BindBody(&data); err != nil {
+				return e.BadRequestError("Invalid data format", err)
+			}
+
+			// 2. Ask the metadata providers
+			book, err := lookup.Lookup(e.Request.Context(), data.ISBN)
+			if err != nil {
+				return isbnLookupError(e, err)
+			}
+
+			// Refuse likely duplicates unless the caller insists with ?force=true
+			if existing, err := findUnforcedDuplicate(e, book.Title, book.Author(), book.ISBN13); err != nil {
+				return e.InternalServerError("Failed to check for duplicate books", err)
+			} else if existing != nil {
+				return duplicateBookResponse(e, existing)
+			}
+
+			// 3. Find the 'books' collection
+			collection, err := app.FindCollectionByNameOrId("books")
+			if err != nil {
+				return e.InternalServerError("Books collection not found", err)
+			}
+
+			// 4. Initialize the record
+			author := book.Author()
+			if author == "" {
+				author = "Unknown author"
+			}
+
+			record := core.NewRecord(collection)
+			record.Set("title", book.Title)
+			record.Set("author", author)
+			record.Set("totalPages", book.Pages)
+			record.Set("isbn", book.ISBN13)
+			record.Set("publisher", book.Publisher)
+			record.Set("publishDate", book.PublishDate)
+			record.Set("description", book.Description)
+			record.Set("coverImageUrl", book.CoverURL)
+			record.Set("status", "planned")
+
+			// 5. Download and attach the cover image, the book is added without one otherwise
+			if book.CoverURL != "" {
+				downloadCover(e, record, book.CoverURL)
+			}
+
+			// 6. Save the record to the database
+			if err := app.Save(record); err != nil {
+				return e.InternalServerError("Failed to save book record", err)
+			}
+
+			return e.JSON(http.StatusOK, record)
+		}).Bind(apis.RequireAuth())
+
 		return se.Next()
 	})
 }
+
+// Helper: Normalize an ISBN given with a book, empty stays empty
+func optionalISBN(isbn string) (string, error) {
+	if strings.TrimSpace(isbn) == "" {
+		return "", nil
+	}
+	return metadata.NormalizeISBN(isbn)
+}
+
+// Helper: The book a new one would duplicate, nil if there is none or the
+// caller forced the addition with ?force=true
+func findUnforcedDuplicate(e *core.RequestEvent, title string, author string, isbn string) (*core.Record, error) {
+	if force, _ := strconv.ParseBool(e.Request.URL.Query().Get("force")); force {
+		return nil, nil
+	}
+	return findDuplicateBook(e.App, title, author, isbn)
+}
+
+// Helper: 409 carrying the existing book, so the client can offer to open it instead
+func duplicateBookResponse(e *core.RequestEvent, existing *core.Record) error {
+	return e.JSON(http.StatusConflict, map[string]any{
+		"status":  http.StatusConflict,
+		"message": "This book already exists. Add ?force=true to add it anyway.",
+		"book":    existing,
+	})
+}
+
+// Helper: Map metadata lookup errors to responses
+func isbnLookupError(e *core.RequestEvent, err error) error {
+	switch {
+	case errors.Is(err, metadata.ErrInvalidISBN):
+		return e.BadRequestError("Invalid ISBN", err)
+	case errors.Is(err, metadata.ErrNotFound):
+		return e.NotFoundError("No metadata found for this ISBN", err)
+	default:
+		return e.Error(http.StatusBadGateway, "Metadata providers are unavailable", err)
+	}
+}
+
+// Helper: 503 while the metadata providers are misconfigured
+func metadataUnavailable(e *core.RequestEvent, err error) error {
+	return e.Error(http.StatusServiceUnavailable, "ISBN lookups are disabled, check the metadata configuration", err)
+}
+
+// Helper: Download a cover image and set it on the book.
+// Books are still added without a cover if that fails, the reason ends up in the logs.
+func downloadCover(e *core.RequestEvent, record *core.Record, url string) {
+	img, err := cover.Fetch(e.Request.Context(), url)
+	if err == nil {
+		err = setCover(record, img, "cover")
+	}
+	if err != nil {
+		e.App.Logger().Warn("Cover download failed", "url", url, "error", err.Error())
+	}
+}
+
+// Helper: Normalize a cover and set it on the book, along with the
+// dominant colour, BlurHash and size the frontend shows while it loads.
+// Thumbnails are generated by PocketBase from the 'thumbs' of the cover field.
+func setCover(record *core.Record, img *cover.Image, name string) error {
+	processed, err := cover.Process(img)
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = "cover"
+	}
+	f, err := filesystem.NewFileFromBytes(processed.Data, name+processed.Ext)
+	if err != nil {
+		return err
+	}
+
+	record.Set("cover", f)
+	record.Set("coverColor", processed.Color)
+	record.Set("coverBlurhash", processed.BlurHash)
+	record.Set("coverWidth", processed.Width)
+	record.Set("coverHeight", processed.Height)
+	return nil
+}
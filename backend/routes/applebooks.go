@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// appleEmailError is a problem with the email itself rather than with the server
+type appleEmailError struct {
+	Message string
+	Err     error
+}
+
+func (e *appleEmailError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *appleEmailError) Unwrap() error {
+	return e.Err
+}
+
+// Apple writes annotation dates in the reader's locale, the time is optional
+var appleDateLayouts = []string{
+	"January 2, 2006 at 3:04:05 PM",
+	"January 2, 2006 at 3:04 PM",
+	"January 2, 2006 at 15:04",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006 at 15:04:05",
+	"2 January 2006 at 15:04",
+	"2 January 2006",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// appleImport is the outcome of importing one Apple Books email
+type appleImport struct {
+	Book        *core.Record
+	BookCreated bool
+	Imported    int
+}
+
+// Helper: Save the notes of an Apple Books "Notes from ..." email for a user.
+// Used by the Mailgun webhook and by the raw email endpoint. sent is the Date
+// header of the email, its time zone is the one the annotation dates are in.
+func importAppleBooksEmail(app core.App, user *core.Record, subject string, htmlContent string, sent time.Time) (*appleImport, error) {
+	// 1. Parse the HTML
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+	if err != nil {
+		return nil, &appleEmailError{"Failed to parse HTML", err}
+	}
+
+	// Extract Book Title & Author
+	title := strings.TrimSpace(doc.Find(".booktitle").Text())
+	author := strings.TrimSpace(doc.Find(".booktitle + h2").First().Text())
+
+	// Fallback if HTML parsing fails (sometimes Apple changes classes)
+	if title == "" {
+		// "Notes from “The Silent Patient”..."
+		if strings.Contains(subject, `“`) && strings.Contains(subject, `”`) {
+			parts := strings.Split(subject, `“`)
+			if len(parts) > 1 {
+				title = strings.Split(parts[1], `”`)[0]
+			}
+		}
+	}
+
+	if title == "" {
+		return nil, &appleEmailError{"Could not determine book title", nil}
+	}
+
+	// 2. Find or create the book.
+	// Apple's titles rarely match ours exactly, so match them loosely
+	result := &appleImport{}
+	result.Book, err = findBookByTitle(app, title, author)
+	if err != nil {
+		return nil, fmt.Errorf("look up books: %w", err)
+	}
+
+	// Unknown books are added as planned so the export isn't lost,
+	// their notes get placed once somebody uploads the book file
+	if result.Book == nil {
+		result.Book, err = createPlannedBook(app, title, author)
+		if err != nil {
+			return nil, fmt.Errorf("create book: %w", err)
+		}
+		result.BookCreated = true
+	}
+
+	// 3. Process the notes
+	notesCollection, err := app.FindCollectionByNameOrId("notes")
+	if err != nil {
+		return nil, fmt.Errorf("notes collection not found: %w", err)
+	}
+
+	doc.Find(".annotation").Each(func(i int, s *goquery.Selection) {
+		quote := strings.TrimSpace(s.Find(".annotationrepresentativetext").Text()) // -> bookText
+		note := strings.TrimSpace(s.Find(".annotationnote").Text())                // -> note
+
+		// Skip empty entries
+		if quote == "" && note == "" {
+			return
+		}
+
+		imported := importedNote{
+			BookText: quote, // Mapped to your 'bookText' field
+			Note:     note,  // Mapped to your 'note' field
+			Source:   "apple_books",
+
+			// Apple gives the chapter ("Chapter One") rather than a page, the note
+			// processor uses it to tell repeated quotes apart
+			Chapter: strings.TrimSpace(s.Find(".annotationchapter").First().Text()),
+			Style:   appleHighlightStyle(s),
+		}
+
+		// Extract raw date string: "December 1, 2025"
+		if rawDate := strings.TrimSpace(s.Find(".annotationdate").First().Text()); rawDate != "" {
+			imported.Created, imported.Zoned = parseAppleDate(rawDate, sent)
+		}
+
+		// Duplicates are skipped to prevent spamming
+		if created, err := saveImportedNote(app, notesCollection, result.Book.Id, user.Id, imported); err == nil && created {
+			result.Imported++
+		}
+	})
+
+	return result, nil
+}
+
+// Helper: Colour of the highlight ("yellow", "green", ...) or "underline".
+// Apple adds it as a class next to annotationselectionMarker.
+func appleHighlightStyle(s *goquery.Selection) string {
+	class, _ := s.Find(".annotationselectionMarker").First().Attr("class")
+	for _, c := range strings.Fields(class) {
+		if c != "annotationselectionMarker" {
+			return strings.ToLower(c)
+		}
+	}
+	return ""
+}
+
+// Helper: Parse an annotation date in the time zone the email was sent from.
+// Reports whether the zone is known, it isn't when the email had no Date header.
+func parseAppleDate(text string, sent time.Time) (time.Time, bool) {
+	// Newer versions put a narrow no-break space before "PM"
+	text = strings.Join(strings.Fields(text), " ")
+
+	loc := time.UTC
+	if !sent.IsZero() {
+		loc = sent.Location()
+	}
+
+	for _, layout := range appleDateLayouts {
+		if t, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return t, !sent.IsZero()
+		}
+	}
+	return time.Time{}, false
+}
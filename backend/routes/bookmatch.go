@@ -0,0 +1,179 @@
+package routes
+
+import (
+	"strings"
+
+	"sheikahslate/matcher"
+	"sheikahslate/metadata"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Helper: Find the book an imported title refers to.
+// Titles from e-readers rarely match ours exactly, so we compare normalized
+// titles (case, punctuation, accents) and fall back to the main title without
+// its subtitle or series suffix. When several books fit, the author decides.
+// A main title alone is too loose ("Dune" vs "Dune Messiah: ..."), so those
+// matches only count when the author matches too.
+func findBookByTitle(app core.App, title string, author string) (*core.Record, error) {
+	books, err := app.FindAllRecords("books")
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := matcher.Normalize(title)
+	wantedMain := matcher.Normalize(mainTitle(title))
+	if wanted == "" {
+		return nil, nil
+	}
+
+	var exact, partial []*core.Record
+	for _, book := range books {
+		bookTitle := book.GetString("title")
+		normalized := matcher.Normalize(bookTitle)
+
+		switch {
+		case normalized == wanted:
+			exact = append(exact, book)
+		case wantedMain != "" && (matcher.Normalize(mainTitle(bookTitle)) == wantedMain || normalized == wantedMain):
+			partial = append(partial, book)
+		}
+	}
+
+	if book := pickByAuthor(exact, author); book != nil {
+		return book, nil
+	}
+	if len(exact) > 0 {
+		return exact[0], nil
+	}
+	return pickByAuthor(partial, author), nil
+}
+
+// Helper: Strip subtitles ("Dune: Deluxe Edition") and series suffixes ("Dune (Dune #1)")
+func mainTitle(title string) string {
+	if i := strings.IndexAny(title, ":(["); i > 0 {
+		title = title[:i]
+	}
+	if i := strings.Index(title, " - "); i > 0 {
+		title = title[:i]
+	}
+	return strings.TrimSpace(title)
+}
+
+// Helper: The first candidate whose author shares a name with the given author, or nil
+func pickByAuthor(books []*core.Record, author string) *core.Record {
+	for _, book := range books {
+		if shareAuthorName(book.GetString("author"), author) {
+			return book
+		}
+	}
+	return nil
+}
+
+// Helper: Find a book that is likely the same as one about to be added.
+// The ISBN decides when both sides have one; otherwise the normalized title,
+// or main title, has to match and the authors have to share a name.
+func findDuplicateBook(app core.App, title string, author string, isbn string) (*core.Record, error) {
+	index, err := newBookIndex(app)
+	if err != nil {
+		return nil, err
+	}
+	return index.findDuplicate(title, author, isbn), nil
+}
+
+// bookIndex holds the library by ISBN and title, so imports that check many
+// books for duplicates load the books only once
+type bookIndex struct {
+	byISBN      map[string]*core.Record
+	byTitle     map[string][]*core.Record // normalized title
+	byMainTitle map[string][]*core.Record // normalized main title
+}
+
+func newBookIndex(app core.App) (*bookIndex, error) {
+	books, err := app.FindAllRecords("books")
+	if err != nil {
+		return nil, err
+	}
+
+	index := &bookIndex{
+		byISBN:      map[string]*core.Record{},
+		byTitle:     map[string][]*core.Record{},
+		byMainTitle: map[string][]*core.Record{},
+	}
+	for _, book := range books {
+		index.add(book)
+	}
+	return index, nil
+}
+
+// add indexes a book, e.g. one just created by an import
+func (index *bookIndex) add(book *core.Record) {
+	if isbn := book.GetString("isbn"); isbn != "" {
+		if _, ok := index.byISBN[isbn]; !ok {
+			index.byISBN[isbn] = book
+		}
+	}
+
+	title := book.GetString("title")
+	if normalized := matcher.Normalize(title); normalized != "" {
+		index.byTitle[normalized] = append(index.byTitle[normalized], book)
+	}
+	if main := matcher.Normalize(mainTitle(title)); main != "" {
+		index.byMainTitle[main] = append(index.byMainTitle[main], book)
+	}
+}
+
+// findDuplicate works like findDuplicateBook on the indexed books
+func (index *bookIndex) findDuplicate(title string, author string, isbn string) *core.Record {
+	isbn13, _ := metadata.NormalizeISBN(isbn)
+	if isbn13 != "" {
+		if book, ok := index.byISBN[isbn13]; ok {
+			return book
+		}
+	}
+
+	wanted := matcher.Normalize(title)
+	if wanted == "" {
+		return nil
+	}
+
+	candidates := append([]*core.Record{}, index.byTitle[wanted]...)
+	if wantedMain := matcher.Normalize(mainTitle(title)); wantedMain != "" {
+		candidates = append(candidates, index.byMainTitle[wantedMain]...)
+	}
+
+	for _, book := range candidates {
+		// Another edition of the same book is still a different book
+		if isbn13 != "" && book.GetString("isbn") != "" {
+			continue
+		}
+
+		if sameAuthor(book.GetString("author"), author) {
+			return book
+		}
+	}
+
+	return nil
+}
+
+// Helper: Whether two author fields share a name. Placeholders and
+// missing authors match anything.
+func sameAuthor(a string, b string) bool {
+	a, b = matcher.Normalize(a), matcher.Normalize(b)
+	if a == "" || b == "" || a == "unknown author" || b == "unknown author" {
+		return true
+	}
+	return shareAuthorName(a, b)
+}
+
+// Helper: Whether two author fields have a name in common, in any order
+// ("Frank Herbert" and "Herbert, Frank"). Initials don't count.
+func shareAuthorName(a string, b string) bool {
+	padded := " " + matcher.Normalize(b) + " "
+	for _, name := range strings.Fields(matcher.Normalize(a)) {
+		if len(name) > 1 && strings.Contains(padded, " "+name+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package routes
+
+import "testing"
+
+func TestAuthorMatching(t *testing.T) {
+	tests := []struct {
+		a, b      string
+		wantShare bool
+		wantSame  bool
+	}{
+		{"Frank Herbert", "Frank Herbert", true, true},
+		{"Frank Herbert", "Herbert, Frank", true, true},
+		{"Gabriel García Márquez", "Gabriel Garcia Marquez", true, true},
+		{"J. R. R. Tolkien", "Tolkien", true, true},
+		{"J. R. R. Tolkien", "J. K. Rowling", false, false}, // initials don't count
+		{"Frank Herbert", "Brian Herbert, Kevin J. Anderson", true, true},
+		{"Frank Herbert", "Ursula K. Le Guin", false, false},
+		{"Frank Herbert", "", false, true},
+		{"Unknown author", "Frank Herbert", false, true},
+	}
+
+	for _, tt := range tests {
+		if got := shareAuthorName(tt.a, tt.b); got != tt.wantShare {
+			t.Errorf("shareAuthorName(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.wantShare)
+		}
+		if got := shareAuthorName(tt.b, tt.a); got != tt.wantShare {
+			t.Errorf("shareAuthorName(%q, %q) = %v, want %v", tt.b, tt.a, got, tt.wantShare)
+		}
+		if got := sameAuthor(tt.a, tt.b); got != tt.wantSame {
+			t.Errorf("sameAuthor(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.wantSame)
+		}
+	}
+}
+
+func TestMainTitle(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Dune", "Dune"},
+		{"Dune: Deluxe Edition", "Dune"},
+		{"Dune (Dune #1)", "Dune"},
+		{"The Hobbit [Illustrated]", "The Hobbit"},
+		{"Emma - A Novel", "Emma"},
+		{"(Untitled)", "(Untitled)"},
+	}
+
+	for _, tt := range tests {
+		if got := mainTitle(tt.title); got != tt.want {
+			t.Errorf("mainTitle(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+
+	"sheikahslate/sessions"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Fields cleared from notes that would spoil the book for the reader
+var spoilerFields = []string{"bookText", "note", "chapter", "alternatives", "startOffset", "endOffset"}
+
+func RegisterBookNotesRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// GET /books/{id}/notes - List the club's notes for a book without spoilers
+		// Notes past the reader's current page are blurred (text removed) by default,
+		// ?hide=true leaves them out completely and ?reveal=true shows everything.
+		se.Router.GET("/books/{id}/notes", func(e *core.RequestEvent) error {
+			bookId := e.Request.PathValue("id")
+			query := e.Request.URL.Query()
+			reveal, _ := strconv.ParseBool(query.Get("reveal"))
+			hide, _ := strconv.ParseBool(query.Get("hide"))
+
+			book, err := app.FindRecordById("books", bookId)
+			if err != nil {
+				return e.NotFoundError("Book not found", err)
+			}
+
+			// 1. Work out how far the reader has got
+			currentPage := 0
+			finished := false
+			if session := sessions.FindReaderSession(app, book.Id, e.Auth.Id); session != nil {
+				currentPage = session.GetInt("currentPage")
+				finished = session.GetString("status") == "completed"
+			}
+
+			// 2. Load every note for the book in reading order
+			notes, err := app.FindRecordsByFilter(
+				"notes",
+				"book = {:bookId}",
+				"page,startOffset,created",
+				0,
+				0,
+				map[string]any{"bookId": book.Id},
+			)
+			if err != nil {
+				return e.InternalServerError("Failed to load notes", err)
+			}
+
+			// 3. Blur or drop the ones ahead of the reader
+			result := make([]map[string]any, 0, len(notes))
+			hiddenCount := 0
+
+			for _, note := range notes {
+				data := note.PublicExport()
+				spoiler := !reveal && !finished && isSpoiler(note, e.Auth.Id, currentPage)
+				data["spoiler"] = spoiler
+
+				if spoiler {
+					hiddenCount++
+					if hide {
+						continue
+					}
+					for _, field := range spoilerFields {
+						delete(data, field)
+					}
+				}
+
+				result = append(result, data)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"book":        book.Id,
+				"currentPage": currentPage,
+				"revealed":    reveal || finished,
+				"hiddenCount": hiddenCount,
+				"notes":       result,
+			})
+		}).Bind(apis.RequireAuth())
+
+		return se.Next()
+	})
+}
+
+// Helper: A note is a spoiler when it sits past the reader's current page.
+// Your own notes are never spoilers, and notes we couldn't place on a page
+// are treated as spoilers because they could be anywhere in the book.
+func isSpoiler(note *core.Record, userId string, currentPage int) bool {
+	if note.GetString("user") == userId {
+		return false
+	}
+
+	page := note.GetInt("page")
+	if page <= 0 || page == 999 {
+		return true
+	}
+
+	return page > currentPage
+}
@@ -0,0 +1,295 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Mailboxes with a year of exports get big
+const emailMaxUpload = 64 << 20
+
+// mboxrd escapes body lines starting with "From " as ">From ", ">>From " ...
+var mboxEscapedFrom = regexp.MustCompile(`(?m)^>(>*From )`)
+
+// Decodes "=?iso-8859-1?q?...?=" headers in any charset the web knows about
+var emailWordDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// rawEmail is what we need from a parsed RFC 822 message
+type rawEmail struct {
+	From       string
+	Recipients []string // values of the headers naming who received the message
+	Subject    string
+	HTML       string
+	Date       time.Time // zero when the message has no valid Date header
+}
+
+func RegisterEmailImportRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// POST /notes/import-email - Import Apple Books emails from raw RFC 822 messages
+		// The body is either the message itself (e.g. piped from a local MTA) or a
+		// multipart upload of a message or mbox file in 'file'. Superusers import for
+		// the member whose private address the message was sent to, anyone else
+		// imports into their own account.
+		se.Router.POST("/notes/import-email", func(e *core.RequestEvent) error {
+			// 1. Read the upload
+			var body io.Reader = e.Request.Body
+			if strings.HasPrefix(e.Request.Header.Get("Content-Type"), "multipart/form-data") {
+				file, _, err := e.Request.FormFile("file")
+				if err != nil {
+					return e.BadRequestError("No 'file' upload found", err)
+				}
+				defer file.Close()
+				body = file
+			}
+
+			data, err := io.ReadAll(body)
+			if err != nil {
+				return e.BadRequestError("Failed to read the message", err)
+			}
+
+			messages := splitMbox(data)
+			if len(messages) == 0 {
+				return e.BadRequestError("No email message found", nil)
+			}
+
+			// 2. Import every message on its own, one bad email shouldn't lose the rest
+			results := make([]map[string]any, 0, len(messages))
+			for _, raw := range messages {
+				results = append(results, importRawEmail(app, e, raw))
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"status":   "success",
+				"messages": results,
+			})
+		}).Bind(apis.RequireAuth(), apis.BodyLimit(emailMaxUpload))
+
+		return se.Next()
+	})
+}
+
+// Helper: Import one raw message and describe the outcome
+func importRawEmail(app core.App, e *core.RequestEvent, raw []byte) map[string]any {
+	result := map[string]any{}
+
+	email, err := parseRawEmail(raw)
+	if err != nil {
+		result["error"] = "Failed to parse the message: " + err.Error()
+		return result
+	}
+	result["subject"] = email.Subject
+	result["from"] = email.From
+
+	if email.HTML == "" {
+		result["error"] = "The message has no HTML body"
+		return result
+	}
+
+	user := e.Auth
+	if e.HasSuperuserAuth() {
+		user = findUserByRecipients(app, email.Recipients...)
+		if user == nil {
+			result["error"] = "The message wasn't sent to a member's inbound address"
+			return result
+		}
+	}
+
+	imported, err := importAppleBooksEmail(app, user, email.Subject, email.HTML, email.Date)
+	if err != nil {
+		var emailErr *appleEmailError
+		if errors.As(err, &emailErr) {
+			result["error"] = emailErr.Message
+		} else {
+			app.Logger().Error("Failed to import email", "subject", email.Subject, "error", err)
+			result["error"] = "Failed to import notes"
+		}
+		return result
+	}
+
+	result["book"] = imported.Book.GetString("title")
+	result["bookId"] = imported.Book.Id
+	result["bookCreated"] = imported.BookCreated
+	result["imported"] = imported.Imported
+	result["user"] = user.GetString("email")
+	return result
+}
+
+// Helper: Split an mbox file into its messages, anything else is a single message
+func splitMbox(data []byte) [][]byte {
+	data = bytes.TrimLeft(data, "\r\n")
+	if len(data) == 0 {
+		return nil
+	}
+	if !bytes.HasPrefix(data, []byte("From ")) {
+		return [][]byte{data}
+	}
+
+	var messages [][]byte
+	var current []byte
+	blank := true
+
+	flush := func() {
+		if msg := bytes.TrimSpace(current); len(msg) > 0 {
+			messages = append(messages, mboxEscapedFrom.ReplaceAll(current, []byte("$1")))
+		}
+		current = nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// A "From " line after a blank line starts the next message
+		if blank && bytes.HasPrefix(line, []byte("From ")) {
+			flush()
+			blank = false
+			continue
+		}
+
+		current = append(current, line...)
+		current = append(current, '\n')
+		blank = len(bytes.TrimRight(line, "\r")) == 0
+	}
+	flush()
+
+	return messages
+}
+
+// Helper: Pull the sender, subject and HTML body out of an RFC 822 message
+func parseRawEmail(raw []byte) (*rawEmail, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+
+	email := &rawEmail{}
+
+	email.Subject, err = emailWordDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		email.Subject = msg.Header.Get("Subject")
+	}
+
+	email.From = parseFromAddress(msg.Header.Get("From"))
+	email.Date, _ = msg.Header.Date()
+
+	// MTAs record the envelope recipient in Delivered-To / X-Original-To
+	for _, header := range []string{"Delivered-To", "X-Original-To", "To", "Cc"} {
+		email.Recipients = append(email.Recipients, msg.Header[header]...)
+	}
+
+	email.HTML, err = findHTMLBody(msg.Header.Get("Content-Type"), msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return email, nil
+}
+
+// Helper: The address in a From header.
+// Some mailers encode the whole header rather than just the name, so when the
+// header doesn't parse we decode it first and try again.
+func parseFromAddress(header string) string {
+	parser := mail.AddressParser{WordDecoder: emailWordDecoder}
+	if from, err := parser.Parse(header); err == nil {
+		return from.Address
+	}
+
+	decoded, err := emailWordDecoder.DecodeHeader(header)
+	if err != nil {
+		return ""
+	}
+	if from, err := parser.Parse(decoded); err == nil {
+		return from.Address
+	}
+	return ""
+}
+
+// Helper: Find the first text/html part, walking into multipart bodies and
+// forwarded messages, and decode it to UTF-8
+func findHTMLBody(contentType string, transferEncoding string, body io.Reader) (string, error) {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			// Raw parts, so quoted-printable is decoded the same way everywhere
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+
+			html, err := findHTMLBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil || html != "" {
+				return html, err
+			}
+		}
+
+	case mediaType == "message/rfc822":
+		forwarded, err := mail.ReadMessage(decodeTransferEncoding(transferEncoding, body))
+		if err != nil {
+			return "", err
+		}
+		return findHTMLBody(forwarded.Header.Get("Content-Type"), forwarded.Header.Get("Content-Transfer-Encoding"), forwarded.Body)
+
+	case mediaType == "text/html":
+		reader := decodeTransferEncoding(transferEncoding, body)
+		if charset := params["charset"]; charset != "" {
+			if reader, err = charsetReader(charset, reader); err != nil {
+				return "", err
+			}
+		}
+
+		html, err := io.ReadAll(reader)
+		return string(html), err
+	}
+
+	return "", nil
+}
+
+// Helper: Undo the Content-Transfer-Encoding of a body
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		// The decoder skips the line breaks itself
+		return base64.NewDecoder(base64.StdEncoding, body)
+	}
+	return body
+}
+
+// Helper: Convert text in a named charset to UTF-8
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
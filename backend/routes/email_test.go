@@ -0,0 +1,154 @@
+package routes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitMbox(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []string
+	}{
+		{
+			name: "single message",
+			data: "Subject: Hi\r\n\r\nBody\r\n",
+			want: []string{"Subject: Hi\r\n\r\nBody\r\n"},
+		},
+		{
+			name: "mbox",
+			data: "From alice@example.com Mon Mar  4 22:15:32 2019\n" +
+				"Subject: One\n\nFirst\n\n" +
+				"From bob@example.com Mon Mar  4 22:16:00 2019\n" +
+				"Subject: Two\n\nSecond\n",
+			want: []string{
+				"Subject: One\n\nFirst\n\n",
+				"Subject: Two\n\nSecond\n",
+			},
+		},
+		{
+			name: "escaped From lines are restored",
+			data: "From alice@example.com Mon Mar  4 22:15:32 2019\n" +
+				"Subject: One\n\n" +
+				">From the start, it was clear.\n" +
+				">>From quoted twice\n" +
+				"From inside a paragraph is not a separator\n",
+			want: []string{
+				"Subject: One\n\n" +
+					"From the start, it was clear.\n" +
+					">From quoted twice\n" +
+					"From inside a paragraph is not a separator\n",
+			},
+		},
+		{
+			name: "empty",
+			data: "\r\n\r\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, msg := range splitMbox([]byte(tt.data)) {
+				got = append(got, string(msg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitMbox() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRawEmail(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want rawEmail
+	}{
+		{
+			name: "multipart/alternative with quoted-printable html",
+			raw: "From: =?UTF-8?Q?Ren=C3=A9e?= <renee@example.com>\r\n" +
+				"To: notes@example.com\r\n" +
+				"Subject: =?UTF-8?Q?Notes_from_=E2=80=9CDune=E2=80=9D?=\r\n" +
+				"Date: Mon, 4 Mar 2019 22:15:32 +0100\r\n" +
+				"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+				"\r\n" +
+				"--b1\r\n" +
+				"Content-Type: text/plain; charset=utf-8\r\n" +
+				"\r\n" +
+				"Plain text\r\n" +
+				"--b1\r\n" +
+				"Content-Type: text/html; charset=utf-8\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"<p class=3D\"x\">Caf=C3=A9 with a very long line that is wrapped by the enco=\r\n" +
+				"der</p>\r\n" +
+				"--b1--\r\n",
+			want: rawEmail{
+				From:       "renee@example.com",
+				Subject:    "Notes from “Dune”",
+				Date:       time.Date(2019, 3, 4, 21, 15, 32, 0, time.UTC),
+				Recipients: []string{"notes@example.com"},
+				HTML:       "<p class=\"x\">Café with a very long line that is wrapped by the encoder</p>",
+			},
+		},
+		{
+			name: "forwarded message in base64 with a latin-1 body",
+			raw: "From: reader@example.com\r\n" +
+				"Delivered-To: notes+abc@example.com\r\n" +
+				"Subject: Fwd: Notes\r\n" +
+				"Content-Type: multipart/mixed; boundary=\"outer\"\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"\r\n" +
+				"See attached\r\n" +
+				"--outer\r\n" +
+				"Content-Type: message/rfc822\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				// Subject: Notes, Content-Type: text/html; charset=iso-8859-1, body "<b>Caf\xe9</b>"
+				"U3ViamVjdDogTm90ZXMNCkNvbnRlbnQtVHlwZTogdGV4dC9odG1sOyBjaGFyc2V0PWlzby04\r\n" +
+				"ODU5LTENCg0KPGI+Q2Fm6TwvYj4=\r\n" +
+				"--outer--\r\n",
+			want: rawEmail{
+				From:       "reader@example.com",
+				Subject:    "Fwd: Notes",
+				Recipients: []string{"notes+abc@example.com"},
+				HTML:       "<b>Café</b>",
+			},
+		},
+		{
+			name: "plain text only",
+			raw:  "From: reader@example.com\r\nSubject: Hi\r\n\r\nNo html here\r\n",
+			want: rawEmail{From: "reader@example.com", Subject: "Hi"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRawEmail([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("parseRawEmail() error = %v", err)
+			}
+
+			if got.From != tt.want.From || got.Subject != tt.want.Subject || got.HTML != tt.want.HTML ||
+				!got.Date.Equal(tt.want.Date) || !reflect.DeepEqual(got.Recipients, tt.want.Recipients) {
+				t.Errorf("parseRawEmail() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindHTMLBodyErrors(t *testing.T) {
+	if _, err := findHTMLBody("text/html; charset=klingon", "", strings.NewReader("<p>x</p>")); err == nil {
+		t.Error("findHTMLBody() accepted an unknown charset")
+	}
+	if _, err := findHTMLBody("multipart/alternative; boundary=b", "", strings.NewReader("--b\r\nbroken")); err == nil {
+		t.Error("findHTMLBody() accepted a truncated multipart body")
+	}
+}
@@ -0,0 +1,395 @@
+package routes
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sheikahslate/sessions"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Characters Obsidian (or the file system) doesn't allow in note names
+var unsafeFilenameChars = strings.NewReplacer(
+	"/", " ", "\\", " ", ":", " -", "*", "", "?", "", "\"", "'",
+	"<", "", ">", "", "|", "-", "#", "", "^", "", "[", "(", "]", ")",
+)
+
+// exportBook is a book and the notes exported for it, in reading order
+type exportBook struct {
+	Book     *core.Record
+	Name     string // file name without extension
+	Notes    []*core.Record
+	NoteName []string // file name of every note in the vault, without extension
+
+	// The exporting reader's progress, for leaving out spoilers
+	CurrentPage int
+	Finished    bool
+}
+
+func RegisterNotesExportRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// GET /notes/export - Export notes as Markdown
+		// ?book=<id> limits the export to one book, ?scope=club includes everyone's notes
+		// (spoilers left out unless ?reveal=true) and ?format=md returns a single file
+		// for one book instead of a zip laid out as an Obsidian vault.
+		se.Router.GET("/notes/export", func(e *core.RequestEvent) error {
+			query := e.Request.URL.Query()
+			bookId := query.Get("book")
+			club := query.Get("scope") == "club"
+			reveal, _ := strconv.ParseBool(query.Get("reveal"))
+			format := query.Get("format")
+			if format == "" {
+				format = "zip"
+			}
+
+			if format != "zip" && format != "md" {
+				return e.BadRequestError("Format must be 'md' or 'zip'", nil)
+			}
+			if format == "md" && bookId == "" {
+				return e.BadRequestError("A single Markdown file needs a 'book'", nil)
+			}
+
+			// 1. Load the notes
+			filter := "book != ''"
+			if bookId != "" {
+				filter += " && book = {:book}"
+			}
+			if !club {
+				filter += " && user = {:user}"
+			}
+
+			notes, err := app.FindRecordsByFilter(
+				"notes",
+				filter,
+				"page,startOffset,created",
+				0,
+				0,
+				map[string]any{"book": bookId, "user": e.Auth.Id},
+			)
+			if err != nil {
+				return e.InternalServerError("Failed to load notes", err)
+			}
+
+			// 2. Group them by book, leaving out what the reader hasn't reached yet
+			books, err := groupNotesByBook(app, notes, e.Auth.Id, club && !reveal)
+			if err != nil {
+				return e.InternalServerError("Failed to load books", err)
+			}
+			if bookId != "" && len(books) == 0 {
+				book, err := app.FindRecordById("books", bookId)
+				if err != nil {
+					return e.NotFoundError("Book not found", err)
+				}
+				books = []*exportBook{newExportBook(book)}
+			}
+
+			// Names are only looked up for the club's notes
+			userNames := map[string]string{}
+			if club {
+				userNames = findUserNames(app, notes)
+			}
+
+			exported := time.Now().UTC()
+
+			// 3. A single Markdown file ...
+			if format == "md" {
+				content := renderBookMarkdown(books[0], userNames, exported)
+				e.Response.Header().Set("Content-Disposition", attachment(books[0].Name+".md"))
+				return e.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(content))
+			}
+
+			// ... or a vault with a file per book and per note
+			archive, err := renderVault(books, userNames, exported)
+			if err != nil {
+				return e.InternalServerError("Failed to create archive", err)
+			}
+
+			e.Response.Header().Set("Content-Disposition", attachment("Book club notes.zip"))
+			return e.Blob(http.StatusOK, "application/zip", archive)
+		}).Bind(apis.RequireAuth())
+
+		return se.Next()
+	})
+}
+
+// Helper: Group notes by book in title order.
+// With hideSpoilers, other members' notes past the reader's page are dropped.
+func groupNotesByBook(app core.App, notes []*core.Record, userId string, hideSpoilers bool) ([]*exportBook, error) {
+	byId := map[string]*exportBook{}
+	var books []*exportBook
+
+	for _, note := range notes {
+		id := note.GetString("book")
+		book, ok := byId[id]
+		if !ok {
+			record, err := app.FindRecordById("books", id)
+			if err != nil {
+				return nil, err
+			}
+			book = newExportBook(record)
+			byId[id] = book
+			books = append(books, book)
+
+			if hideSpoilers {
+				if session := sessions.FindReaderSession(app, id, userId); session != nil {
+					book.CurrentPage = session.GetInt("currentPage")
+					book.Finished = session.GetString("status") == "completed"
+				}
+			}
+		}
+
+		if hideSpoilers && !book.Finished && isSpoiler(note, userId, book.CurrentPage) {
+			continue
+		}
+		book.Notes = append(book.Notes, note)
+	}
+
+	sort.SliceStable(books, func(i, j int) bool {
+		return strings.ToLower(books[i].Name) < strings.ToLower(books[j].Name)
+	})
+
+	// Two books with the same title still need their own files
+	names := map[string]int{}
+	for _, book := range books {
+		names[book.Name]++
+		if n := names[book.Name]; n > 1 {
+			book.Name += " (" + strconv.Itoa(n) + ")"
+		}
+	}
+
+	for _, book := range books {
+		book.NoteName = make([]string, len(book.Notes))
+		for i, note := range book.Notes {
+			book.NoteName[i] = fmt.Sprintf("%s p%d %s", book.Name, notePage(note), note.Id)
+		}
+	}
+
+	return books, nil
+}
+
+func newExportBook(book *core.Record) *exportBook {
+	name := strings.Join(strings.Fields(unsafeFilenameChars.Replace(book.GetString("title"))), " ")
+
+	// A leading dot hides the file, and "." or ".." would leave the vault's folders
+	name = strings.TrimSpace(strings.TrimLeft(name, "."))
+	if name == "" {
+		name = book.Id
+	}
+	return &exportBook{Book: book, Name: name}
+}
+
+// Helper: Map the authors of the notes to their display names
+func findUserNames(app core.App, notes []*core.Record) map[string]string {
+	names := map[string]string{}
+	var ids []string
+	for _, note := range notes {
+		if id := note.GetString("user"); id != "" && names[id] == "" {
+			names[id] = id
+			ids = append(ids, id)
+		}
+	}
+
+	users, err := app.FindRecordsByIds("users", ids)
+	if err != nil {
+		return names
+	}
+	for _, user := range users {
+		if name := user.GetString("name"); name != "" {
+			names[user.Id] = name
+		}
+	}
+	return names
+}
+
+// Helper: One Markdown file for a book with all its notes inline
+func renderBookMarkdown(book *exportBook, userNames map[string]string, exported time.Time) string {
+	var b strings.Builder
+	writeBookHeader(&b, book, exported)
+
+	for _, note := range book.Notes {
+		b.WriteString("\n## ")
+		b.WriteString(noteHeading(note))
+		b.WriteString("\n\n")
+		writeNoteBody(&b, note)
+
+		var meta []string
+		if chapter := note.GetString("chapter"); chapter != "" {
+			meta = append(meta, chapter)
+		}
+		if date := noteDate(note); date != "" {
+			meta = append(meta, date)
+		}
+		if name := userNames[note.GetString("user")]; name != "" {
+			meta = append(meta, name)
+		}
+		if len(meta) > 0 {
+			b.WriteString("*" + strings.Join(meta, " · ") + "*\n")
+		}
+	}
+
+	return b.String()
+}
+
+// Helper: Zip laid out as an Obsidian vault
+//
+//	Books/<title>.md                      front matter and links to the notes
+//	Notes/<title>/<title> p12 <id>.md     one file per note with its own front matter
+func renderVault(books []*exportBook, userNames map[string]string, exported time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	add := func(name string, content string) error {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: exported})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write([]byte(content))
+		return err
+	}
+
+	for _, book := range books {
+		var index strings.Builder
+		writeBookHeader(&index, book, exported)
+		index.WriteString("\n")
+
+		for i, note := range book.Notes {
+			notePath := path.Join("Notes", book.Name, book.NoteName[i])
+			fmt.Fprintf(&index, "- [[%s|%s]]: %s\n", notePath, noteHeading(note), notePreview(note))
+
+			if err := add(notePath+".md", renderNoteFile(book, note, userNames)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := add(path.Join("Books", book.Name+".md"), index.String()); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Helper: A note file of the vault
+func renderNoteFile(book *exportBook, note *core.Record, userNames map[string]string) string {
+	var b strings.Builder
+
+	b.WriteString("---\n")
+	writeYAML(&b, "title", book.Book.GetString("title"))
+	writeYAML(&b, "author", book.Book.GetString("author"))
+	if page := notePage(note); page > 0 {
+		fmt.Fprintf(&b, "page: %d\n", page)
+	}
+	writeYAML(&b, "chapter", note.GetString("chapter"))
+	if date := noteDate(note); date != "" {
+		fmt.Fprintf(&b, "date: %s\n", date)
+	}
+	writeYAML(&b, "user", userNames[note.GetString("user")])
+	writeYAML(&b, "source", note.GetString("source"))
+	b.WriteString("tags:\n  - highlight\n")
+	b.WriteString("---\n\n")
+
+	writeNoteBody(&b, note)
+	fmt.Fprintf(&b, "From [[%s]]\n", path.Join("Books", book.Name))
+
+	return b.String()
+}
+
+func writeBookHeader(b *strings.Builder, book *exportBook, exported time.Time) {
+	title := book.Book.GetString("title")
+	author := book.Book.GetString("author")
+
+	b.WriteString("---\n")
+	writeYAML(b, "title", title)
+	writeYAML(b, "author", author)
+	fmt.Fprintf(b, "notes: %d\n", len(book.Notes))
+	fmt.Fprintf(b, "date: %s\n", exported.Format("2006-01-02"))
+	b.WriteString("tags:\n  - book\n")
+	b.WriteString("---\n\n")
+
+	b.WriteString("# " + title + "\n")
+	if author != "" {
+		b.WriteString("*" + author + "*\n")
+	}
+}
+
+// Helper: The highlighted text as a quote followed by the reader's note
+func writeNoteBody(b *strings.Builder, note *core.Record) {
+	if text := strings.TrimSpace(note.GetString("bookText")); text != "" {
+		for _, line := range strings.Split(text, "\n") {
+			b.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if text := strings.TrimSpace(note.GetString("note")); text != "" {
+		b.WriteString(text + "\n\n")
+	}
+}
+
+// Helper: Quoted YAML scalar, skipped when empty
+func writeYAML(b *strings.Builder, key string, value string) {
+	if value == "" {
+		return
+	}
+	b.WriteString(key + ": " + strconv.Quote(value) + "\n")
+}
+
+// Helper: Page of a note, 0 when it couldn't be placed
+func notePage(note *core.Record) int {
+	page := note.GetInt("page")
+	if page == 999 {
+		return 0
+	}
+	return page
+}
+
+func noteHeading(note *core.Record) string {
+	if page := notePage(note); page > 0 {
+		return "Page " + strconv.Itoa(page)
+	}
+	return "Unknown page"
+}
+
+// Helper: Day the highlight was made, or else the day the note was added
+func noteDate(note *core.Record) string {
+	created := note.GetDateTime("created")
+	if created.IsZero() {
+		created = note.GetDateTime("sys_created")
+	}
+	if created.IsZero() {
+		return ""
+	}
+	return created.Time().Format("2006-01-02")
+}
+
+// Helper: First words of a note for the vault index
+func notePreview(note *core.Record) string {
+	text := note.GetString("bookText")
+	if text == "" {
+		text = note.GetString("note")
+	}
+	text = strings.Join(strings.Fields(text), " ")
+
+	runes := []rune(text)
+	if len(runes) > 80 {
+		return string(runes[:80]) + "…"
+	}
+	return text
+}
+
+func attachment(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"unicode/utf8"
+
+	"sheikahslate/matcher"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// highlightRange is the part of a paragraph covered by a highlight.
+// Offsets are in characters (Unicode code points), End is exclusive.
+type highlightRange struct {
+	Paragraph int `json:"paragraph"`
+	Start     int `json:"start"`
+	End       int `json:"end"`
+}
+
+type pageHighlight struct {
+	Id     string           `json:"id"`
+	User   string           `json:"user"`
+	Text   string           `json:"bookText"`
+	Note   string           `json:"note"`
+	Ranges []highlightRange `json:"ranges"`
+}
+
+// Helper: Find the notes located on a page and work out which paragraphs they cover.
+// The part of each highlight that falls on this page is searched for in the
+// paragraphs with the same matcher the note processor uses.
+func findPageHighlights(app core.App, bookId string, page int, content string, paragraphs []string) ([]pageHighlight, error) {
+	notes, err := app.FindRecordsByFilter(
+		"notes",
+		"book = {:bookId} && page > 0 && page != 999 && page <= {:page} && (endPage >= {:page} || page = {:page})",
+		"page,startOffset",
+		0,
+		0,
+		map[string]any{"bookId": bookId, "page": page},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	highlights := []pageHighlight{}
+	if len(notes) == 0 {
+		return highlights, nil
+	}
+
+	book := matcher.NewBook(paragraphs)
+
+	for _, note := range notes {
+		quote := highlightOnPage(note, page, content)
+		if quote == "" {
+			continue
+		}
+
+		match, found := book.Find(quote)
+		if !found {
+			continue
+		}
+
+		highlights = append(highlights, pageHighlight{
+			Id:     note.Id,
+			User:   note.GetString("user"),
+			Text:   note.GetString("bookText"),
+			Note:   note.GetString("note"),
+			Ranges: paragraphRanges(match, paragraphs),
+		})
+	}
+
+	return highlights, nil
+}
+
+// Helper: Cut the part of a highlight that falls on this page out of the page text.
+// Notes matched before offsets were stored fall back to their full text.
+func highlightOnPage(note *core.Record, page int, content string) string {
+	endPage := note.GetInt("endPage")
+	if endPage == 0 {
+		return note.GetString("bookText")
+	}
+
+	runes := []rune(content)
+	start, end := 0, len(runes)
+
+	if note.GetInt("page") == page {
+		start = note.GetInt("startOffset")
+	}
+	if endPage == page {
+		end = note.GetInt("endOffset")
+	}
+
+	if start < 0 || end > len(runes) || start >= end {
+		return note.GetString("bookText")
+	}
+	return string(runes[start:end])
+}
+
+// Helper: Spread a match over the paragraphs it covers
+func paragraphRanges(match matcher.Match, paragraphs []string) []highlightRange {
+	var ranges []highlightRange
+
+	for p := match.Page; p <= match.EndPage; p++ {
+		r := highlightRange{
+			Paragraph: p - 1,
+			Start:     0,
+			End:       utf8.RuneCountInString(paragraphs[p-1]),
+		}
+		if p == match.Page {
+			r.Start = match.StartOffset
+		}
+		if p == match.EndPage {
+			r.End = match.EndOffset
+		}
+		ranges = append(ranges, r)
+	}
+
+	return ranges
+}
@@ -0,0 +1,135 @@
+package routes
+
+import (
+	"net/http"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Domain the inbound email provider receives note imports on, e.g. "notes.example.com"
+const inboundDomainEnv = "INBOUND_EMAIL_DOMAIN"
+
+// Email local parts are case-insensitive in practice, so tokens are lowercase
+const (
+	inboundTokenLength   = 24
+	inboundTokenAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+)
+
+// RegisterInboundAddressRoutes gives every member a private address to mail their
+// notes to, <token>@INBOUND_EMAIL_DOMAIN (or anything+<token>@ for catch-all
+// routes). The token is the only thing that identifies the member, so notes can be
+// forwarded from any account and a forged sender gets nowhere.
+func RegisterInboundAddressRoutes(app core.App) {
+	app.OnRecordCreate("users").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("inboundToken") == "" {
+			e.Record.Set("inboundToken", newInboundToken())
+		}
+		return e.Next()
+	})
+
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// GET /users/me/inbound - The member's private import address
+		se.Router.GET("/users/me/inbound", func(e *core.RequestEvent) error {
+			user, err := app.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found", err)
+			}
+
+			// Accounts from before tokens existed get one on first use
+			if user.GetString("inboundToken") == "" {
+				user.Set("inboundToken", newInboundToken())
+				if err := app.Save(user); err != nil {
+					return e.InternalServerError("Failed to create inbound address", err)
+				}
+			}
+
+			return e.JSON(http.StatusOK, inboundAddressResponse(user))
+		}).Bind(apis.RequireAuth("users"))
+
+		// POST /users/me/inbound/rotate - Replace the address, e.g. after it leaked
+		se.Router.POST("/users/me/inbound/rotate", func(e *core.RequestEvent) error {
+			user, err := app.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found", err)
+			}
+
+			user.Set("inboundToken", newInboundToken())
+			if err := app.Save(user); err != nil {
+				return e.InternalServerError("Failed to rotate inbound address", err)
+			}
+
+			return e.JSON(http.StatusOK, inboundAddressResponse(user))
+		}).Bind(apis.RequireAuth("users"))
+
+		return se.Next()
+	})
+}
+
+func newInboundToken() string {
+	return security.RandomStringWithAlphabet(inboundTokenLength, inboundTokenAlphabet)
+}
+
+func inboundAddressResponse(user *core.Record) map[string]any {
+	token := user.GetString("inboundToken")
+
+	address := ""
+	if domain := os.Getenv(inboundDomainEnv); domain != "" {
+		address = token + "@" + domain
+	}
+
+	return map[string]any{
+		"token":   token,
+		"address": address,
+	}
+}
+
+// Helper: Find the member an email was sent to.
+// Every recipient header value may hold several addresses; the token is the
+// local part, or the part after the last "+" of it.
+func findUserByRecipients(app core.App, recipients ...string) *core.Record {
+	for _, value := range recipients {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		addresses, err := mail.ParseAddressList(value)
+		if err != nil {
+			// Mailgun's 'recipient' field is a bare comma separated list
+			addresses = nil
+			for _, part := range strings.Split(value, ",") {
+				addresses = append(addresses, &mail.Address{Address: strings.TrimSpace(part)})
+			}
+		}
+
+		for _, address := range addresses {
+			token := inboundTokenFromAddress(address.Address)
+			if len(token) != inboundTokenLength {
+				continue
+			}
+
+			user, err := app.FindFirstRecordByData("users", "inboundToken", token)
+			if err == nil {
+				return user
+			}
+		}
+	}
+
+	return nil
+}
+
+func inboundTokenFromAddress(address string) string {
+	local, _, found := strings.Cut(address, "@")
+	if !found {
+		return ""
+	}
+	if i := strings.LastIndex(local, "+"); i >= 0 {
+		local = local[i+1:]
+	}
+	return strings.ToLower(strings.TrimSpace(local))
+}
@@ -0,0 +1,381 @@
+package routes
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Kindle separates entries in My Clippings.txt with this line
+const kindleSeparator = "=========="
+
+// kindleClipping is one entry of a My Clippings.txt file
+type kindleClipping struct {
+	Title    string
+	Author   string
+	Kind     string // highlight, note or bookmark
+	Page     int
+	Location string // "180-182", or "183" for notes
+	Added    time.Time
+	Text     string
+	Note     string // the note written on a highlight, see mergeKindleNotes
+}
+
+// Words Kindle uses for the entry kind, in the languages it ships with.
+// Anything we don't recognise is treated as a highlight.
+var kindleKinds = []struct {
+	Kind  string
+	Words []string
+}{
+	{"bookmark", []string{"bookmark", "lesezeichen", "signet", "marque-page", "marcador", "segnalibro", "bladwijzer", "ブックマーク"}},
+	{"note", []string{"note", "notiz", "nota", "notitie", "メモ"}},
+	{"highlight", []string{"highlight", "markierung", "surlignement", "subrayado", "evidenziazione", "destaque", "markering", "ハイライト"}},
+}
+
+var (
+	kindlePageRegex     = regexp.MustCompile(`(?i)(?:page|seite|página|pagina|pagine|bladzijde|ページ)\s*(\d+)`)
+	kindleLocationRegex = regexp.MustCompile(`(?i)(?:location|loc\.|position|posición|posizione|emplacement|posição|locatie|位置No\.)\s*(\d+(?:-\d+)?)`)
+	kindleClockRegex    = regexp.MustCompile(`(\d{1,2}):(\d{2})(?::(\d{2}))?`)
+	kindleAuthorRegex   = regexp.MustCompile(`^(.*)\(([^()]*)\)\s*$`)
+)
+
+// Month names in the languages Kindle ships with
+var kindleMonths = map[string]time.Month{}
+
+func init() {
+	names := [][]string{
+		{"january", "jan", "januar", "janvier", "enero", "gennaio", "janeiro", "januari"},
+		{"february", "feb", "februar", "février", "fevrier", "febrero", "febbraio", "fevereiro", "februari"},
+		{"march", "mar", "märz", "marz", "mars", "marzo", "março", "marco", "maart"},
+		{"april", "apr", "avril", "abril", "aprile"},
+		{"may", "mai", "mayo", "maggio", "maio", "mei"},
+		{"june", "jun", "juni", "juin", "junio", "giugno", "junho"},
+		{"july", "jul", "juli", "juillet", "julio", "luglio", "julho"},
+		{"august", "aug", "août", "aout", "agosto", "augustus"},
+		{"september", "sep", "sept", "septembre", "septiembre", "settembre", "setembro"},
+		{"october", "oct", "oktober", "octobre", "octubre", "ottobre", "outubro"},
+		{"november", "nov", "novembre", "noviembre", "novembro"},
+		{"december", "dec", "dezember", "décembre", "decembre", "diciembre", "dicembre", "dezembro"},
+	}
+
+	for i, list := range names {
+		for _, name := range list {
+			kindleMonths[name] = time.Month(i + 1)
+		}
+	}
+}
+
+func RegisterKindleImportRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// POST /notes/import-kindle - Import highlights from a Kindle "My Clippings.txt" upload
+		se.Router.POST("/notes/import-kindle", func(e *core.RequestEvent) error {
+			// 1. Read the uploaded file
+			file, _, err := e.Request.FormFile("file")
+			if err != nil {
+				return e.BadRequestError("No 'file' upload found", err)
+			}
+			defer file.Close()
+
+			clippings, err := parseKindleClippings(file)
+			if err != nil {
+				return e.BadRequestError("Failed to read clippings file", err)
+			}
+
+			notesCollection, err := app.FindCollectionByNameOrId("notes")
+			if err != nil {
+				return e.InternalServerError("Notes collection not found", err)
+			}
+
+			// 2. Match every clipping to one of our books and save it
+			imported := map[string]int{}
+			skipped := 0
+			bookmarks := 0
+			unmatched := []string{}
+			books := map[string]*core.Record{}
+
+			for _, c := range mergeKindleNotes(clippings) {
+				if c.Kind == "bookmark" {
+					bookmarks++
+					continue
+				}
+
+				key := c.Title + "\x00" + c.Author
+				book, seen := books[key]
+				if !seen {
+					book, err = findBookByTitle(app, c.Title, c.Author)
+					if err != nil {
+						return e.InternalServerError("Failed to look up books", err)
+					}
+					books[key] = book
+
+					if book == nil {
+						unmatched = append(unmatched, c.Title)
+					}
+				}
+				if book == nil {
+					continue
+				}
+
+				note := importedNote{
+					BookText: c.Text,
+					Note:     c.Note,
+					Created:  c.Added,
+					Source:   "kindle",
+					Location: kindleLocationLabel(c),
+				}
+
+				// Notes that aren't attached to a highlight have no book text
+				if c.Kind == "note" {
+					note.BookText = ""
+					note.Note = c.Text
+				}
+
+				created, err := saveImportedNote(app, notesCollection, book.Id, e.Auth.Id, note)
+				if err != nil {
+					return e.InternalServerError("Failed to save note", err)
+				}
+				if created {
+					imported[book.GetString("title")]++
+				} else {
+					skipped++
+				}
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"status":            "success",
+				"imported":          imported,
+				"duplicatesSkipped": skipped,
+				"bookmarksSkipped":  bookmarks,
+				"unmatchedBooks":    unmatched,
+			})
+		}).Bind(apis.RequireAuth())
+
+		return se.Next()
+	})
+}
+
+// Helper: Parse the entries of a My Clippings.txt file
+func parseKindleClippings(r io.Reader) ([]kindleClipping, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4<<20)
+
+	var clippings []kindleClipping
+	var lines []string
+
+	flush := func() {
+		if c, ok := parseKindleEntry(lines); ok {
+			clippings = append(clippings, c)
+		}
+		lines = nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == kindleSeparator {
+			flush()
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+
+	return clippings, scanner.Err()
+}
+
+// Helper: Parse a single entry
+//
+//	The Title (Author Name)
+//	- Your Highlight on page 12 | Location 180-182 | Added on Monday, March 4, 2019 10:15:32 PM
+//
+//	The highlighted text
+func parseKindleEntry(lines []string) (kindleClipping, bool) {
+	// Drop blank lines around the entry
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) < 2 {
+		return kindleClipping{}, false
+	}
+
+	c := kindleClipping{}
+
+	// Title line, the file starts with a byte order mark
+	header := strings.TrimSpace(strings.TrimPrefix(lines[0], "\ufeff"))
+	if m := kindleAuthorRegex.FindStringSubmatch(header); m != nil && strings.TrimSpace(m[1]) != "" {
+		c.Title = strings.TrimSpace(m[1])
+		c.Author = strings.TrimSpace(m[2])
+	} else {
+		c.Title = header
+	}
+
+	// Metadata line: kind, page, location and date separated by "|"
+	meta := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[1]), "-"))
+	parts := strings.Split(meta, "|")
+	c.Kind = kindleKind(parts[0])
+
+	for _, part := range parts {
+		if m := kindlePageRegex.FindStringSubmatch(part); m != nil {
+			c.Page, _ = strconv.Atoi(m[1])
+		}
+		if m := kindleLocationRegex.FindStringSubmatch(part); m != nil {
+			c.Location = m[1]
+		}
+	}
+	if len(parts) > 1 {
+		c.Added = parseKindleDate(parts[len(parts)-1])
+	}
+
+	c.Text = strings.TrimSpace(strings.Join(lines[2:], "\n"))
+	if c.Kind != "bookmark" && c.Text == "" {
+		return kindleClipping{}, false
+	}
+
+	return c, true
+}
+
+// Helper: Work out whether an entry is a highlight, note or bookmark
+func kindleKind(label string) string {
+	label = strings.ToLower(label)
+	for _, k := range kindleKinds {
+		for _, word := range k.Words {
+			if strings.Contains(label, word) {
+				return k.Kind
+			}
+		}
+	}
+	return "highlight"
+}
+
+// Helper: Parse the "Added on ..." part in any of Kindle's languages.
+// Rather than one layout per language we pick out the month name, the
+// day, the four digit year and the clock time wherever they appear.
+func parseKindleDate(text string) time.Time {
+	text = strings.ToLower(text)
+
+	hour, minute, second := 0, 0, 0
+	if m := kindleClockRegex.FindStringSubmatch(text); m != nil {
+		hour, _ = strconv.Atoi(m[1])
+		minute, _ = strconv.Atoi(m[2])
+		second, _ = strconv.Atoi(m[3])
+		text = strings.Replace(text, m[0], " ", 1)
+	}
+
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '.' || r == ':' || r == '/' || r == '年' || r == '月' || r == '日'
+	})
+
+	var month time.Month
+	var numbers []int
+	pm, am := false, false
+
+	for _, f := range fields {
+		if n, err := strconv.Atoi(f); err == nil {
+			numbers = append(numbers, n)
+			continue
+		}
+		if m, ok := kindleMonths[f]; ok {
+			month = m
+		}
+		switch f {
+		case "pm", "p.m", "午後":
+			pm = true
+		case "am", "a.m", "午前":
+			am = true
+		}
+	}
+
+	year, day := 0, 0
+	var rest []int
+	for _, n := range numbers {
+		if n >= 1000 && year == 0 {
+			year = n
+		} else {
+			rest = append(rest, n)
+		}
+	}
+
+	// Numeric months (Japanese "2019年3月4日") come before the day
+	if month == 0 && len(rest) >= 2 {
+		month = time.Month(rest[0])
+		rest = rest[1:]
+	}
+	if len(rest) > 0 {
+		day = rest[0]
+	}
+
+	if year == 0 || month < 1 || month > 12 || day < 1 || day > 31 {
+		return time.Time{}
+	}
+
+	if pm && hour < 12 {
+		hour += 12
+	}
+	if am && hour == 12 {
+		hour = 0
+	}
+
+	return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+}
+
+// Helper: Attach Kindle notes to the highlight they were written on.
+// Kindle stores a note as its own entry at the location where the highlight ends.
+func mergeKindleNotes(clippings []kindleClipping) []kindleClipping {
+	attached := make([]bool, len(clippings))
+
+	for i, c := range clippings {
+		if c.Kind != "note" {
+			continue
+		}
+
+		for j := range clippings {
+			h := clippings[j]
+			if h.Kind != "highlight" || h.Note != "" || h.Title != c.Title || !kindleLocationEndsAt(h.Location, c.Location) {
+				continue
+			}
+
+			clippings[j].Note = c.Text
+			attached[i] = true
+			break
+		}
+	}
+
+	var result []kindleClipping
+	for i, c := range clippings {
+		if !attached[i] {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Helper: Check whether a highlight location range ends where a note sits
+func kindleLocationEndsAt(rangeLocation string, noteLocation string) bool {
+	if rangeLocation == "" || noteLocation == "" {
+		return false
+	}
+	_, end, found := strings.Cut(rangeLocation, "-")
+	if !found {
+		end = rangeLocation
+	}
+	return end == noteLocation
+}
+
+// Helper: Human readable position on the Kindle, e.g. "page 12, location 180-182"
+func kindleLocationLabel(c kindleClipping) string {
+	var parts []string
+	if c.Page > 0 {
+		parts = append(parts, "page "+strconv.Itoa(c.Page))
+	}
+	if c.Location != "" {
+		parts = append(parts, "location "+c.Location)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKindleClippings(t *testing.T) {
+	file := "\ufeffDune: Deluxe Edition (Herbert, Frank)\r\n" +
+		"- Your Highlight on page 12 | Location 180-182 | Added on Monday, March 4, 2019 10:15:32 PM\r\n" +
+		"\r\n" +
+		"Fear is the mind-killer.\r\n" +
+		"==========\r\n" +
+		"Der Prozess (Kafka, Franz)\r\n" +
+		"- Ihre Markierung auf Seite 5 | Position 70-71 | Hinzugefügt am Montag, 4. März 2019 22:15:32\r\n" +
+		"\r\n" +
+		"Jemand musste Josef K. verleumdet haben\r\n" +
+		"==========\r\n" +
+		"L'Étranger (Camus, Albert)\r\n" +
+		"- Votre note sur la page 9 | emplacement 120 | Ajouté le lundi 4 mars 2019 09:05:00\r\n" +
+		"\r\n" +
+		"Aujourd'hui, maman est morte.\r\n" +
+		"==========\r\n" +
+		"吾輩は猫である (夏目 漱石)\r\n" +
+		"- 位置No. 1234-1235のハイライト |作成日: 2019年3月4日月曜日 22:15:32\r\n" +
+		"\r\n" +
+		"吾輩は猫である。名前はまだ無い。\r\n" +
+		"==========\r\n" +
+		"Dune: Deluxe Edition (Herbert, Frank)\r\n" +
+		"- Your Bookmark on page 20 | Location 300 | Added on Monday, March 4, 2019 10:16:00 PM\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"==========\r\n" +
+		"Dune: Deluxe Edition (Herbert, Frank)\r\n" +
+		"- Your Highlight on page 13 | Location 190-191 | Added on Monday, March 4, 2019 10:17:00 PM\r\n" +
+		"\r\n" +
+		"\r\n" +
+		"==========\r\n" +
+		"Notes Without Author\r\n" +
+		"- Your Highlight at location 10-12 | Added on Monday, March 4, 2019 10:15:32 PM\r\n" +
+		"\r\n" +
+		"First line\r\n" +
+		"second line\r\n" +
+		"==========\r\n"
+
+	want := []kindleClipping{
+		{
+			Title: "Dune: Deluxe Edition", Author: "Herbert, Frank", Kind: "highlight", Page: 12, Location: "180-182",
+			Added: time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC), Text: "Fear is the mind-killer.",
+		},
+		{
+			Title: "Der Prozess", Author: "Kafka, Franz", Kind: "highlight", Page: 5, Location: "70-71",
+			Added: time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC), Text: "Jemand musste Josef K. verleumdet haben",
+		},
+		{
+			Title: "L'Étranger", Author: "Camus, Albert", Kind: "note", Page: 9, Location: "120",
+			Added: time.Date(2019, 3, 4, 9, 5, 0, 0, time.UTC), Text: "Aujourd'hui, maman est morte.",
+		},
+		{
+			Title: "吾輩は猫である", Author: "夏目 漱石", Kind: "highlight", Location: "1234-1235",
+			Added: time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC), Text: "吾輩は猫である。名前はまだ無い。",
+		},
+		{
+			Title: "Dune: Deluxe Edition", Author: "Herbert, Frank", Kind: "bookmark", Page: 20, Location: "300",
+			Added: time.Date(2019, 3, 4, 22, 16, 0, 0, time.UTC),
+		},
+		// The empty highlight is dropped
+		{
+			Title: "Notes Without Author", Kind: "highlight", Location: "10-12",
+			Added: time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC), Text: "First line\nsecond line",
+		},
+	}
+
+	got, err := parseKindleClippings(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("parseKindleClippings() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseKindleClippings() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseKindleDate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want time.Time
+	}{
+		{"english pm", "Added on Monday, March 4, 2019 10:15:32 PM", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"english am", "Added on Monday, March 4, 2019 9:05:00 AM", time.Date(2019, 3, 4, 9, 5, 0, 0, time.UTC)},
+		{"12 am is midnight", "Added on Sunday, December 1, 2019 12:30:00 AM", time.Date(2019, 12, 1, 0, 30, 0, 0, time.UTC)},
+		{"12 pm is noon", "Added on Sunday, December 1, 2019 12:30:00 PM", time.Date(2019, 12, 1, 12, 30, 0, 0, time.UTC)},
+		{"english day first", "Added on Monday, 4 March 2019 22:15:32", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"german", "Hinzugefügt am Montag, 4. März 2019 22:15:32", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"french", "Ajouté le lundi 4 août 2019 07:01:02", time.Date(2019, 8, 4, 7, 1, 2, 0, time.UTC)},
+		{"spanish", "Añadido el lunes, 4 de marzo de 2019 22:15:32", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"japanese", "作成日: 2019年3月4日月曜日 22:15:32", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"japanese afternoon", "作成日: 2019年3月4日 午後10:15:32", time.Date(2019, 3, 4, 22, 15, 32, 0, time.UTC)},
+		{"no year", "Added on Monday, March 4 10:15:32 PM", time.Time{}},
+		{"garbage", "whenever", time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseKindleDate(tt.text); !got.Equal(tt.want) {
+				t.Errorf("parseKindleDate(%q) = %v, want %v", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeKindleNotes(t *testing.T) {
+	clippings := []kindleClipping{
+		{Title: "Dune", Kind: "highlight", Location: "180-182", Text: "Fear is the mind-killer."},
+		{Title: "Dune", Kind: "note", Location: "182", Text: "So true"},
+		{Title: "Dune", Kind: "note", Location: "600", Text: "A note on its own"},
+		{Title: "Emma", Kind: "note", Location: "182", Text: "Same location, other book"},
+		{Title: "Dune", Kind: "highlight", Location: "700-701", Text: "Second highlight"},
+		{Title: "Dune", Kind: "note", Location: "701", Text: "First note on it"},
+		{Title: "Dune", Kind: "note", Location: "701", Text: "Second note on it"},
+	}
+
+	want := []kindleClipping{
+		{Title: "Dune", Kind: "highlight", Location: "180-182", Text: "Fear is the mind-killer.", Note: "So true"},
+		{Title: "Dune", Kind: "note", Location: "600", Text: "A note on its own"},
+		{Title: "Emma", Kind: "note", Location: "182", Text: "Same location, other book"},
+		{Title: "Dune", Kind: "highlight", Location: "700-701", Text: "Second highlight", Note: "First note on it"},
+		{Title: "Dune", Kind: "note", Location: "701", Text: "Second note on it"},
+	}
+
+	if got := mergeKindleNotes(clippings); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeKindleNotes() =\n%+v\nwant\n%+v", got, want)
+	}
+}
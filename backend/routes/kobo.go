@@ -0,0 +1,220 @@
+package routes
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// KoboReader.sqlite grows with the store catalogue, so allow more than the default body limit
+const koboMaxUpload = 256 << 20
+
+// Kobo writes dates with or without milliseconds and timezone, always in UTC
+var koboDateLayouts = []string{
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05Z",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05",
+}
+
+// koboBookmark is one highlight or note from the Bookmark table
+type koboBookmark struct {
+	Title      string
+	Author     string
+	Chapter    string
+	Text       string
+	Annotation string
+	Added      time.Time
+}
+
+func RegisterKoboImportRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// POST /notes/import-kobo - Import highlights from a Kobo "KoboReader.sqlite" upload
+		se.Router.POST("/notes/import-kobo", func(e *core.RequestEvent) error {
+			// 1. SQLite needs a real file, so copy the upload to a temp file
+			file, _, err := e.Request.FormFile("file")
+			if err != nil {
+				return e.BadRequestError("No 'file' upload found", err)
+			}
+			defer file.Close()
+
+			tmp, err := os.CreateTemp("", "kobo-*.sqlite")
+			if err != nil {
+				return e.InternalServerError("Failed to store upload", err)
+			}
+			defer os.Remove(tmp.Name())
+
+			_, err = io.Copy(tmp, file)
+			tmp.Close()
+			if err != nil {
+				return e.InternalServerError("Failed to store upload", err)
+			}
+
+			bookmarks, err := readKoboBookmarks(tmp.Name())
+			if err != nil {
+				return e.BadRequestError("Not a readable KoboReader.sqlite database", err)
+			}
+
+			notesCollection, err := app.FindCollectionByNameOrId("notes")
+			if err != nil {
+				return e.InternalServerError("Notes collection not found", err)
+			}
+
+			// 2. Match every bookmark to one of our books and save it
+			imported := map[string]int{}
+			skipped := 0
+			unmatched := []string{}
+			books := map[string]*core.Record{}
+
+			for _, b := range bookmarks {
+				key := b.Title + "\x00" + b.Author
+				book, seen := books[key]
+				if !seen {
+					book, err = findBookByTitle(app, b.Title, b.Author)
+					if err != nil {
+						return e.InternalServerError("Failed to look up books", err)
+					}
+					books[key] = book
+
+					if book == nil {
+						unmatched = append(unmatched, b.Title)
+					}
+				}
+				if book == nil {
+					continue
+				}
+
+				created, err := saveImportedNote(app, notesCollection, book.Id, e.Auth.Id, importedNote{
+					BookText: b.Text,
+					Note:     b.Annotation,
+					Created:  b.Added,
+					Source:   "kobo",
+					Chapter:  b.Chapter,
+				})
+				if err != nil {
+					return e.InternalServerError("Failed to save note", err)
+				}
+				if created {
+					imported[book.GetString("title")]++
+				} else {
+					skipped++
+				}
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"status":            "success",
+				"imported":          imported,
+				"duplicatesSkipped": skipped,
+				"unmatchedBooks":    unmatched,
+			})
+		}).Bind(apis.RequireAuth(), apis.BodyLimit(koboMaxUpload))
+
+		return se.Next()
+	})
+}
+
+// Helper: Read the visible highlights and notes from a Kobo database.
+// Bookmarks without text or annotation are dog-ears (page bookmarks) and are left out.
+func readKoboBookmarks(path string) ([]koboBookmark, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	chapters, err := readKoboChapters(db)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT
+			COALESCE(v.Title, ''),
+			COALESCE(v.Attribution, ''),
+			COALESCE(b.ContentID, ''),
+			COALESCE(b.Text, ''),
+			COALESCE(b.Annotation, ''),
+			COALESCE(b.DateCreated, '')
+		FROM Bookmark b
+		LEFT JOIN content v ON v.ContentID = b.VolumeID
+		WHERE COALESCE(b.Hidden, 'false') != 'true'
+		ORDER BY b.VolumeID, b.DateCreated`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bookmarks []koboBookmark
+	for rows.Next() {
+		var b koboBookmark
+		var contentId, added string
+		if err := rows.Scan(&b.Title, &b.Author, &contentId, &b.Text, &b.Annotation, &added); err != nil {
+			return nil, err
+		}
+
+		b.Text = strings.TrimSpace(b.Text)
+		b.Annotation = strings.TrimSpace(b.Annotation)
+		if b.Title == "" || (b.Text == "" && b.Annotation == "") {
+			continue
+		}
+
+		b.Chapter = koboChapter(chapters, contentId)
+		b.Added = parseKoboDate(added)
+		bookmarks = append(bookmarks, b)
+	}
+
+	return bookmarks, rows.Err()
+}
+
+// Helper: Map every chapter ContentID to its title
+func readKoboChapters(db *sql.DB) (map[string]string, error) {
+	rows, err := db.Query(`SELECT ContentID, COALESCE(Title, '') FROM content WHERE ContentType != 6`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chapters := map[string]string{}
+	for rows.Next() {
+		var id, title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		if title = strings.TrimSpace(title); title != "" {
+			chapters[id] = title
+		}
+	}
+	return chapters, rows.Err()
+}
+
+// Helper: Find the chapter title for a bookmark.
+// Sideloaded books use the bookmark's ContentID as is, kepubs from the
+// Kobo store add a "-1" suffix to the chapter row (ContentType 899).
+func koboChapter(chapters map[string]string, contentId string) string {
+	if contentId == "" {
+		return ""
+	}
+	if title, ok := chapters[contentId]; ok {
+		return title
+	}
+	return chapters[contentId+"-1"]
+}
+
+// Helper: Parse Kobo's DateCreated, zero when missing or unknown
+func parseKoboDate(text string) time.Time {
+	text = strings.TrimSpace(text)
+	for _, layout := range koboDateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,270 @@
+package routes
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"sheikahslate/sessions"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Error codes of the KOReader sync protocol, KOReader shows the message to the reader
+const (
+	kosyncErrorUnknown         = 2000
+	kosyncErrorUnauthorized    = 2001
+	kosyncErrorInvalidFields   = 2003
+	kosyncErrorDocumentMissing = 2004
+)
+
+// kosyncProgress is the reading position KOReader sends and expects back.
+// Progress is an XPointer for reflowable books (EPUB) and a page number for PDFs.
+type kosyncProgress struct {
+	Document   string  `json:"document"`
+	Progress   string  `json:"progress"`
+	Percentage float64 `json:"percentage"`
+	Device     string  `json:"device"`
+	DeviceId   string  `json:"device_id"`
+	Timestamp  int64   `json:"timestamp,omitempty"`
+	Page       int     `json:"page,omitempty"` // the currentPage we stored for it, not part of the protocol
+}
+
+// Sync passwords are typed into KOReader by hand, so no look-alike characters
+const (
+	kosyncPasswordLength   = 20
+	kosyncPasswordAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// RegisterKosyncRoutes implements the KOReader progress sync server under /kosync,
+// so members point KOReader's "Custom sync server" at https://<host>/kosync.
+// Readers log in with their email and a sync password from /users/me/kosync,
+// never their account password. KOReader sends an MD5 of the password, we only
+// keep a SHA-256 of that MD5 (kosyncKeyHash).
+func RegisterKosyncRoutes(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// GET /users/me/kosync - Whether the member has a sync password
+		se.Router.GET("/users/me/kosync", func(e *core.RequestEvent) error {
+			return e.JSON(http.StatusOK, map[string]any{
+				"username": e.Auth.Email(),
+				"enabled":  e.Auth.GetString("kosyncKeyHash") != "",
+			})
+		}).Bind(apis.RequireAuth("users"))
+
+		// POST /users/me/kosync/rotate - Create a new sync password, the old one stops working.
+		// The password is only shown in this response.
+		se.Router.POST("/users/me/kosync/rotate", func(e *core.RequestEvent) error {
+			user, err := app.FindRecordById("users", e.Auth.Id)
+			if err != nil {
+				return e.NotFoundError("User not found", err)
+			}
+
+			password := security.RandomStringWithAlphabet(kosyncPasswordLength, kosyncPasswordAlphabet)
+			sum := md5.Sum([]byte(password))
+			user.Set("kosyncKeyHash", kosyncKeyHash(hex.EncodeToString(sum[:])))
+			if err := app.Save(user); err != nil {
+				return e.InternalServerError("Failed to create sync password", err)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"username": user.Email(),
+				"password": password,
+			})
+		}).Bind(apis.RequireAuth("users"))
+
+		kosync := se.Router.Group("/kosync")
+
+		// POST /kosync/users/create - Accounts come from club invites. Every request
+		// gets the same answer so it doesn't tell which emails are registered.
+		kosync.POST("/users/create", func(e *core.RequestEvent) error {
+			return kosyncError(e, http.StatusForbidden, kosyncErrorInvalidFields, "Registration is disabled, log in with your sync password from the book club app")
+		})
+
+		// GET /kosync/users/auth - Check the x-auth-user / x-auth-key headers
+		kosync.GET("/users/auth", func(e *core.RequestEvent) error {
+			if kosyncUser(app, e) == nil {
+				return kosyncError(e, http.StatusUnauthorized, kosyncErrorUnauthorized, "Unauthorized")
+			}
+			return e.JSON(http.StatusOK, map[string]any{"authorized": "OK"})
+		})
+
+		// PUT /kosync/syncs/progress - Store the reading position of a document
+		kosync.PUT("/syncs/progress", func(e *core.RequestEvent) error {
+			user := kosyncUser(app, e)
+			if user == nil {
+				return kosyncError(e, http.StatusUnauthorized, kosyncErrorUnauthorized, "Unauthorized")
+			}
+
+			progress := kosyncProgress{}
+			if err := e.BindBody(&progress); err != nil || progress.Progress == "" {
+				return kosyncError(e, http.StatusForbidden, kosyncErrorInvalidFields, "Invalid request")
+			}
+			if progress.Document == "" {
+				return kosyncError(e, http.StatusForbidden, kosyncErrorDocumentMissing, "Field 'document' not provided")
+			}
+
+			// 1. Find the book the document belongs to
+			file, book := findDocumentBook(app, progress.Document)
+			if book == nil {
+				return kosyncError(e, http.StatusForbidden, kosyncErrorInvalidFields, "This document is not a book in the club")
+			}
+
+			// 2. Find or start the reader's session
+			session := sessions.FindReaderSession(app, book.Id, user.Id)
+			if session == nil {
+				collection, err := app.FindCollectionByNameOrId("readers_sessions")
+				if err != nil {
+					return kosyncError(e, http.StatusInternalServerError, kosyncErrorUnknown, "Unknown server error")
+				}
+				session = core.NewRecord(collection)
+				session.Set("book", book.Id)
+				session.Set("user", user.Id)
+				session.Set("status", "active")
+				session.Set("bookTotalPages", book.GetInt("totalPages"))
+			}
+
+			// 3. Turn the position into a page and keep the raw position for other devices
+			progress.Timestamp = time.Now().Unix()
+			progress.Page = kosyncPage(progress, file, book)
+
+			session.Set("currentPage", progress.Page)
+			session.Set("syncProgress", progress)
+			if err := app.Save(session); err != nil {
+				return kosyncError(e, http.StatusInternalServerError, kosyncErrorUnknown, "Unknown server error")
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"document":  progress.Document,
+				"timestamp": progress.Timestamp,
+			})
+		})
+
+		// GET /kosync/syncs/progress/{document} - Latest reading position of a document
+		kosync.GET("/syncs/progress/{document}", func(e *core.RequestEvent) error {
+			user := kosyncUser(app, e)
+			if user == nil {
+				return kosyncError(e, http.StatusUnauthorized, kosyncErrorUnauthorized, "Unauthorized")
+			}
+
+			document := e.Request.PathValue("document")
+			file, book := findDocumentBook(app, document)
+			if book == nil {
+				return e.JSON(http.StatusOK, map[string]any{})
+			}
+
+			session := sessions.FindReaderSession(app, book.Id, user.Id)
+			if session == nil {
+				return e.JSON(http.StatusOK, map[string]any{})
+			}
+
+			stored := kosyncProgress{}
+			_ = session.UnmarshalJSONField("syncProgress", &stored)
+			currentPage := session.GetInt("currentPage")
+
+			// The page was changed in the app since the last sync. PDF positions are plain
+			// page numbers, so we can send that instead; EPUB positions we can't rebuild.
+			if currentPage > 0 && currentPage != stored.Page && file.GetString("filetype") == "pdf" {
+				total := max(file.GetInt("pageCount"), 1)
+				stored = kosyncProgress{
+					Progress:   strconv.Itoa(currentPage),
+					Percentage: math.Min(float64(currentPage)/float64(total), 1),
+					Device:     "bookclub",
+					DeviceId:   "bookclub",
+					Timestamp:  stored.Timestamp,
+				}
+			}
+
+			if stored.Progress == "" {
+				return e.JSON(http.StatusOK, map[string]any{})
+			}
+
+			stored.Document = document
+			stored.Page = 0
+			return e.JSON(http.StatusOK, stored)
+		})
+
+		return se.Next()
+	})
+}
+
+// Helper: What we store for the key KOReader sends, the MD5 hex of the sync password.
+// The password is random, so a fast hash is enough to keep a dump from being useful.
+func kosyncKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(key)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper: Authenticate a KOReader request, nil when the headers don't match a user
+func kosyncUser(app core.App, e *core.RequestEvent) *core.Record {
+	email := strings.TrimSpace(e.Request.Header.Get("x-auth-user"))
+	key := strings.ToLower(strings.TrimSpace(e.Request.Header.Get("x-auth-key")))
+	if email == "" || key == "" {
+		return nil
+	}
+
+	user, err := app.FindAuthRecordByEmail("users", email)
+	if err != nil {
+		return nil
+	}
+
+	stored := user.GetString("kosyncKeyHash")
+	if stored == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(kosyncKeyHash(key))) != 1 {
+		return nil
+	}
+	return user
+}
+
+// Helper: Find the uploaded file with a KOReader document hash and its book
+func findDocumentBook(app core.App, document string) (*core.Record, *core.Record) {
+	if document == "" {
+		return nil, nil
+	}
+
+	file, err := app.FindFirstRecordByData("files", "documentHash", strings.ToLower(document))
+	if err != nil {
+		return nil, nil
+	}
+
+	book, err := app.FindRecordById("books", file.GetString("book"))
+	if err != nil {
+		return nil, nil
+	}
+	return file, book
+}
+
+// Helper: Work out the page for a KOReader position.
+// PDF positions are page numbers; for EPUBs KOReader's pages depend on the
+// font size, so we place the percentage on the book's own page count.
+func kosyncPage(progress kosyncProgress, file *core.Record, book *core.Record) int {
+	total := book.GetInt("totalPages")
+	if total <= 0 {
+		total = file.GetInt("pageCount")
+	}
+
+	if page, err := strconv.Atoi(strings.TrimSpace(progress.Progress)); err == nil && page > 0 {
+		if total > 0 {
+			page = min(page, total)
+		}
+		return page
+	}
+
+	percentage := math.Max(0, math.Min(progress.Percentage, 1))
+	return int(math.Round(percentage * float64(total)))
+}
+
+// Helper: Errors in the shape KOReader expects
+func kosyncError(e *core.RequestEvent, status int, code int, message string) error {
+	return e.JSON(status, map[string]any{
+		"code":    code,
+		"message": message,
+	})
+}
@@ -0,0 +1,330 @@
+package routes
+
+import (
+	"encoding/csv"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sheikahslate/metadata"
+	"sheikahslate/sessions"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// libraryColumns names the columns of an export we read, by its header row
+type libraryColumns struct {
+	Title, Author, ISBN, ISBN13, Pages, Publisher, Year string
+	Shelf, Rating, Review, DateRead                     string
+}
+
+var goodreadsColumns = libraryColumns{
+	Title:     "title",
+	Author:    "author",
+	ISBN:      "isbn",
+	ISBN13:    "isbn13",
+	Pages:     "number of pages",
+	Publisher: "publisher",
+	Year:      "original publication year",
+	Shelf:     "exclusive shelf",
+	Rating:    "my rating",
+	Review:    "my review",
+	DateRead:  "date read",
+}
+
+var storyGraphColumns = libraryColumns{
+	Title:    "title",
+	Author:   "authors",
+	ISBN:     "isbn/uid",
+	Shelf:    "read status",
+	Rating:   "star rating",
+	Review:   "review",
+	DateRead: "last date read",
+}
+
+// Shelves map to the book status (for new books) and the reader's session status.
+// Unknown custom shelves are treated as "to-read".
+var libraryShelves = map[string]struct{ BookStatus, SessionStatus string }{
+	"read":              {"completed", "completed"},
+	"currently-reading": {"reading", "active"},
+	"paused":            {"reading", "active"},
+	"to-read":           {"planned", ""},
+	"did-not-finish":    {"dropped", "dropped"},
+	"dnf":               {"dropped", "dropped"},
+	"abandoned":         {"dropped", "dropped"},
+}
+
+var libraryBreakRegex = regexp.MustCompile(`(?i)<br\s*/?>`)
+
+// libraryRow is the outcome of one CSV row
+type libraryRow struct {
+	Row     int    `json:"row"` // line in the file, the header is line 1
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Result  string `json:"result"` // created, skipped or failed
+	Reason  string `json:"reason,omitempty"`
+	BookId  string `json:"bookId,omitempty"`
+	Session string `json:"session,omitempty"` // created or exists, when sessions were asked for
+}
+
+func RegisterLibraryImportRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// POST /books/import - Seed the library from a Goodreads or StoryGraph CSV export.
+		// With sessions=true the importing member also gets reading sessions
+		// with their ratings, reviews and finish dates.
+		se.Router.POST("/books/import", func(e *core.RequestEvent) error {
+			// 1. Read the uploaded file
+			file, _, err := e.Request.FormFile("file")
+			if err != nil {
+				return e.BadRequestError("No 'file' upload found", err)
+			}
+			defer file.Close()
+
+			withSessions, _ := strconv.ParseBool(e.Request.FormValue("sessions"))
+
+			reader := csv.NewReader(file)
+			reader.FieldsPerRecord = -1
+			reader.LazyQuotes = true
+
+			header, err := reader.Read()
+			if err != nil {
+				return e.BadRequestError("Failed to read CSV file", err)
+			}
+			index := map[string]int{}
+			for i, name := range header {
+				index[libraryHeader(name)] = i
+			}
+
+			columns, source, ok := libraryFormat(index)
+			if !ok {
+				return e.BadRequestError("Not a Goodreads or StoryGraph export", nil)
+			}
+			field := func(record []string, column string) string {
+				i, ok := index[column]
+				if column == "" || !ok || i >= len(record) {
+					return ""
+				}
+				return libraryValue(record[i])
+			}
+
+			booksCollection, err := app.FindCollectionByNameOrId("books")
+			if err != nil {
+				return e.InternalServerError("Books collection not found", err)
+			}
+			sessionsCollection, err := app.FindCollectionByNameOrId("readers_sessions")
+			if err != nil {
+				return e.InternalServerError("Readers sessions collection not found", err)
+			}
+
+			// Loaded once, the books this import adds are indexed as it goes
+			library, err := newBookIndex(app)
+			if err != nil {
+				return e.InternalServerError("Failed to load books", err)
+			}
+
+			// 2. Add every row
+			rows := []libraryRow{}
+			counts := map[string]int{"created": 0, "skipped": 0, "failed": 0, "sessions": 0}
+
+			for {
+				record, err := reader.Read()
+				if err == io.EOF {
+					break
+				}
+
+				if err != nil {
+					var parseErr *csv.ParseError
+					row := libraryRow{Result: "failed", Reason: err.Error()}
+					if errors.As(err, &parseErr) {
+						row.Row = parseErr.StartLine
+					}
+					rows = append(rows, row)
+					counts["failed"]++
+
+					// Malformed rows are skipped, anything else ends the file
+					if parseErr == nil {
+						break
+					}
+					continue
+				}
+
+				// Reviews may span lines, so count the lines the reader saw
+				line, _ := reader.FieldPos(0)
+				row := libraryRow{Row: line}
+
+				row.Title = field(record, columns.Title)
+				row.Author = field(record, columns.Author)
+				if row.Title == "" {
+					row.Result, row.Reason = "failed", "no title"
+					rows = append(rows, row)
+					counts["failed"]++
+					continue
+				}
+
+				isbn := libraryISBN(field(record, columns.ISBN13), field(record, columns.ISBN))
+				shelf := libraryShelf(field(record, columns.Shelf))
+
+				// Books already in the library are skipped, the reader's session still counts
+				book := library.findDuplicate(row.Title, row.Author, isbn)
+				if book != nil {
+					row.Result, row.Reason = "skipped", "already in the library"
+				} else {
+					author := row.Author
+					if author == "" {
+						author = "Unknown author"
+					}
+					pages, _ := strconv.Atoi(field(record, columns.Pages))
+
+					book = core.NewRecord(booksCollection)
+					book.Set("title", row.Title)
+					book.Set("author", author)
+					book.Set("totalPages", pages)
+					book.Set("isbn", isbn)
+					book.Set("publisher", field(record, columns.Publisher))
+					book.Set("publishDate", field(record, columns.Year))
+					book.Set("status", libraryShelves[shelf].BookStatus)
+
+					if err := app.Save(book); err != nil {
+						row.Result, row.Reason = "failed", "could not save book"
+						rows = append(rows, row)
+						counts["failed"]++
+						continue
+					}
+					library.add(book)
+					row.Result = "created"
+				}
+				row.BookId = book.Id
+				counts[row.Result]++
+
+				// 3. The member's own reading of the book
+				if withSessions && libraryShelves[shelf].SessionStatus != "" {
+					created, err := saveLibrarySession(app, sessionsCollection, book, e.Auth.Id, libraryShelves[shelf].SessionStatus,
+						field(record, columns.Rating), field(record, columns.Review), field(record, columns.DateRead))
+					switch {
+					case err != nil:
+						row.Session = "failed"
+					case created:
+						row.Session = "created"
+						counts["sessions"]++
+					default:
+						row.Session = "exists"
+					}
+				}
+
+				rows = append(rows, row)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"status": "success",
+				"source": source,
+				"counts": counts,
+				"rows":   rows,
+			})
+		}).Bind(apis.RequireAuth("users"), apis.BodyLimit(32<<20))
+
+		return se.Next()
+	})
+}
+
+// Helper: Tell Goodreads and StoryGraph exports apart by their columns
+func libraryFormat(index map[string]int) (libraryColumns, string, bool) {
+	_, hasTitle := index["title"]
+	_, hasShelf := index["exclusive shelf"]
+	_, hasStatus := index["read status"]
+
+	switch {
+	case hasTitle && hasShelf:
+		return goodreadsColumns, "goodreads", true
+	case hasTitle && hasStatus:
+		return storyGraphColumns, "storygraph", true
+	}
+	return libraryColumns{}, "", false
+}
+
+// Helper: Column names are matched case-insensitively, Excel adds a BOM to the first
+func libraryHeader(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// Helper: Goodreads wraps some values as ="..." so spreadsheets keep them as text
+func libraryValue(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, `="`) && strings.HasSuffix(value, `"`) {
+		value = value[2 : len(value)-1]
+	}
+	return strings.TrimSpace(value)
+}
+
+// Helper: The first valid ISBN of the given values, as ISBN-13
+func libraryISBN(values ...string) string {
+	for _, value := range values {
+		if isbn13, err := metadata.NormalizeISBN(value); err == nil {
+			return isbn13
+		}
+	}
+	return ""
+}
+
+// Helper: Normalize a shelf name, anything unknown is "to-read"
+func libraryShelf(shelf string) string {
+	shelf = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(shelf)), " ", "-")
+	if _, ok := libraryShelves[shelf]; !ok {
+		return "to-read"
+	}
+	return shelf
+}
+
+// Helper: Create the member's session on a book unless they already have one.
+// Reports whether a session was created.
+func saveLibrarySession(app core.App, collection *core.Collection, book *core.Record, userId string, status string, rating string, review string, dateRead string) (bool, error) {
+	if sessions.FindReaderSession(app, book.Id, userId) != nil {
+		return false, nil
+	}
+
+	totalPages := book.GetInt("totalPages")
+
+	session := core.NewRecord(collection)
+	session.Set("book", book.Id)
+	session.Set("user", userId)
+	session.Set("status", status)
+	session.Set("bookTotalPages", totalPages)
+
+	if status == "completed" {
+		session.Set("currentPage", totalPages)
+		if ended, ok := parseLibraryDate(dateRead); ok {
+			session.Set("ended", ended)
+		}
+	}
+
+	// 0 means unrated in both exports
+	if value, err := strconv.ParseFloat(rating, 64); err == nil && value > 0 {
+		session.Set("rating", value)
+	}
+
+	// Goodreads keeps the line breaks of reviews as HTML
+	if review != "" {
+		session.Set("review", html.UnescapeString(libraryBreakRegex.ReplaceAllString(review, "\n")))
+	}
+
+	if err := app.Save(session); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Helper: Both exports write dates as 2024/03/31, older ones with dashes
+func parseLibraryDate(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006/01/02", "2006-01-02", "2006/1/2"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
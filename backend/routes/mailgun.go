@@ -0,0 +1,126 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/hook"
+)
+
+// Mailgun signs every webhook with the "HTTP webhook signing key" of the account
+const mailgunSigningKeyEnv = "MAILGUN_SIGNING_KEY"
+
+// How far a webhook timestamp may be from our clock
+const mailgunMaxAge = 5 * time.Minute
+
+// Tokens seen within mailgunMaxAge, older ones fail the timestamp check anyway
+var mailgunTokens = struct {
+	sync.Mutex
+	seen map[string]time.Time
+}{seen: map[string]time.Time{}}
+
+// requireMailgunSignature only lets through requests signed by Mailgun.
+// The form must carry timestamp, token and signature, where signature is the
+// hex HMAC-SHA256 of timestamp+token with the signing key from MAILGUN_SIGNING_KEY.
+// Each token is accepted once. Rejected requests are logged and get a 403.
+func requireMailgunSignature() *hook.Handler[*core.RequestEvent] {
+	return &hook.Handler[*core.RequestEvent]{
+		Func: func(e *core.RequestEvent) error {
+			err := verifyMailgunSignature(
+				os.Getenv(mailgunSigningKeyEnv),
+				e.Request.FormValue("timestamp"),
+				e.Request.FormValue("token"),
+				e.Request.FormValue("signature"),
+				time.Now(),
+			)
+			if err != nil {
+				e.App.Logger().Warn(
+					"Rejected inbound email webhook",
+					"path", e.Request.URL.Path,
+					"ip", e.RealIP(),
+					"sender", e.Request.FormValue("sender"),
+					"reason", err.Error(),
+				)
+				return e.ForbiddenError("Invalid webhook signature", nil)
+			}
+
+			return e.Next()
+		},
+	}
+}
+
+// Helper: Check a Mailgun signature and remember its token
+func verifyMailgunSignature(key string, timestamp string, token string, signature string, now time.Time) error {
+	if key == "" {
+		return errors.New(mailgunSigningKeyEnv + " is not configured")
+	}
+	if timestamp == "" || token == "" || signature == "" {
+		return errors.New("missing timestamp, token or signature")
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > mailgunMaxAge || age < -mailgunMaxAge {
+		return errors.New("timestamp outside the allowed window")
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	expected := mac.Sum(nil)
+
+	given, err := hex.DecodeString(signature)
+	if err != nil || !hmac.Equal(given, expected) {
+		return errors.New("signature mismatch")
+	}
+
+	// Only a correctly signed token counts as used
+	mailgunTokens.Lock()
+	defer mailgunTokens.Unlock()
+
+	for t, seen := range mailgunTokens.seen {
+		if now.Sub(seen) > 2*mailgunMaxAge {
+			delete(mailgunTokens.seen, t)
+		}
+	}
+	if _, replayed := mailgunTokens.seen[token]; replayed {
+		return errors.New("token already used")
+	}
+	mailgunTokens.seen[token] = now
+
+	return nil
+}
+
+// Helper: The Date header of the forwarded email. Mailgun posts it as a field of
+// its own on most routes and always in the JSON list of 'message-headers'.
+func mailgunDate(e *core.RequestEvent) time.Time {
+	value := e.Request.FormValue("Date")
+
+	if value == "" {
+		var headers [][2]string
+		_ = json.Unmarshal([]byte(e.Request.FormValue("message-headers")), &headers)
+		for _, header := range headers {
+			if strings.EqualFold(header[0], "Date") {
+				value = header[1]
+				break
+			}
+		}
+	}
+
+	date, err := mail.ParseDate(value)
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
@@ -0,0 +1,77 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func signMailgun(key string, timestamp string, token string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyMailgunSignature(t *testing.T) {
+	const key = "key-test"
+	now := time.Unix(1700000000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-mailgunMaxAge-time.Second).Unix(), 10)
+
+	tests := []struct {
+		name      string
+		key       string
+		timestamp string
+		token     string
+		signature string
+		wantErr   string
+	}{
+		{"valid", key, timestamp, "token-valid", signMailgun(key, timestamp, "token-valid"), ""},
+		{"no signing key configured", "", timestamp, "token-nokey", signMailgun(key, timestamp, "token-nokey"), "not configured"},
+		{"missing fields", key, timestamp, "", "", "missing"},
+		{"invalid timestamp", key, "yesterday", "token-ts", signMailgun(key, "yesterday", "token-ts"), "invalid timestamp"},
+		{"stale timestamp", key, stale, "token-stale", signMailgun(key, stale, "token-stale"), "outside the allowed window"},
+		{"signed with another key", key, timestamp, "token-other", signMailgun("key-other", timestamp, "token-other"), "mismatch"},
+		{"signature for another token", key, timestamp, "token-swapped", signMailgun(key, timestamp, "token-valid"), "mismatch"},
+		{"signature not hex", key, timestamp, "token-hex", "not-hex", "mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyMailgunSignature(tt.key, tt.timestamp, tt.token, tt.signature, now)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("verifyMailgunSignature() error = %v, want none", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("verifyMailgunSignature() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMailgunSignatureReplay(t *testing.T) {
+	const key = "key-test"
+	now := time.Unix(1700001000, 0)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := signMailgun(key, timestamp, "token-replay")
+
+	if err := verifyMailgunSignature(key, timestamp, "token-replay", signature, now); err != nil {
+		t.Fatalf("first delivery rejected: %v", err)
+	}
+	if err := verifyMailgunSignature(key, timestamp, "token-replay", signature, now.Add(time.Minute)); err == nil {
+		t.Error("replayed token accepted")
+	}
+
+	// A forged request doesn't use up the token of a real one
+	forged := signMailgun("key-other", timestamp, "token-forged")
+	if err := verifyMailgunSignature(key, timestamp, "token-forged", forged, now); err == nil {
+		t.Fatal("forged signature accepted")
+	}
+	if err := verifyMailgunSignature(key, timestamp, "token-forged", signMailgun(key, timestamp, "token-forged"), now); err != nil {
+		t.Errorf("real delivery after a forged one rejected: %v", err)
+	}
+}
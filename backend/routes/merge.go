@@ -0,0 +1,288 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"path/filepath"
+
+	"sheikahslate/cron"
+	"sheikahslate/sessions"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/hook"
+)
+
+// Book fields copied from the duplicate when the kept book has none
+var mergedBookFields = []string{"isbn", "publisher", "publishDate", "description", "coverImageUrl"}
+
+// Cover details that belong with the cover file
+var mergedCoverFields = []string{"coverColor", "coverBlurhash", "coverWidth", "coverHeight"}
+
+// bookMerge counts what a merge moved over
+type bookMerge struct {
+	Files           int `json:"files"`
+	Notes           int `json:"notes"`
+	BookSessions    int `json:"bookSessions"`
+	ReadersSessions int `json:"readersSessions"`
+	// Readers who had a session on both books, their sessions were combined
+	CombinedSessions int `json:"combinedSessions"`
+}
+
+// RegisterBookMergeRoute lets admins fold a duplicate book into the one to keep
+func RegisterBookMergeRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+
+		// POST /books/{id}/merge - Move everything from the book in 'from' to {id}, then delete it
+		se.Router.POST("/books/{id}/merge", func(e *core.RequestEvent) error {
+			// 1. Parse the body
+			data := struct {
+				From string `json:"from"`
+			}{}
+			if err := e.BindBody(&data); err != nil {
+				return e.BadRequestError("Invalid data format", err)
+			}
+
+			// 2. Find both books
+			target, err := app.FindRecordById("books", e.Request.PathValue("id"))
+			if err != nil {
+				return e.NotFoundError("Book not found", err)
+			}
+			source, err := app.FindRecordById("books", data.From)
+			if err != nil {
+				return e.NotFoundError("Book to merge not found", err)
+			}
+			if source.Id == target.Id {
+				return e.BadRequestError("A book can't be merged into itself", nil)
+			}
+
+			// 3. Move everything over and delete the duplicate
+			var result bookMerge
+			var requeued []string
+			err = app.RunInTransaction(func(txApp core.App) error {
+				var err error
+				result, requeued, err = mergeBooks(txApp, target, source)
+				return err
+			})
+			if err != nil {
+				return e.InternalServerError("Failed to merge books", err)
+			}
+
+			// Notes placed in the other book's file get matched again
+			for _, noteId := range requeued {
+				cron.EnqueueNote(noteId)
+			}
+
+			return e.JSON(http.StatusOK, map[string]any{
+				"book":  target,
+				"moved": result,
+			})
+		}).Bind(requireBookAdmin())
+
+		return se.Next()
+	})
+}
+
+// requireBookAdmin only lets through superusers and members with the admin or super role
+func requireBookAdmin() *hook.Handler[*core.RequestEvent] {
+	return &hook.Handler[*core.RequestEvent]{
+		Func: func(e *core.RequestEvent) error {
+			if e.HasSuperuserAuth() {
+				return e.Next()
+			}
+			if e.Auth == nil {
+				return e.UnauthorizedError("The request requires valid record authorization token.", nil)
+			}
+
+			switch e.Auth.GetString("role") {
+			case "admin", "super":
+				return e.Next()
+			}
+			return e.ForbiddenError("Only admins can merge books", nil)
+		},
+	}
+}
+
+// Helper: Move the files, notes and sessions of source to target, fill in the
+// book details target is missing and delete source. Returns the notes that
+// need matching again.
+func mergeBooks(app core.App, target *core.Record, source *core.Record) (bookMerge, []string, error) {
+	var result bookMerge
+	var requeued []string
+
+	// Files: the kept book's primary file stays primary
+	targetPrimary, _ := app.FindFirstRecordByFilter(
+		"files",
+		"book = {:bookId} && primaryFile = true",
+		map[string]any{"bookId": target.Id},
+	)
+
+	files, err := findByBook(app, "files", source.Id)
+	if err != nil {
+		return result, nil, err
+	}
+	for _, file := range files {
+		file.Set("book", target.Id)
+		if targetPrimary != nil {
+			file.Set("primaryFile", false)
+		}
+		if err := app.Save(file); err != nil {
+			return result, nil, err
+		}
+		result.Files++
+	}
+
+	// Notes: their pages point into the duplicate's file, which is only still
+	// the one they are read from if the kept book had no primary file of its own
+	notes, err := findByBook(app, "notes", source.Id)
+	if err != nil {
+		return result, nil, err
+	}
+	for _, note := range notes {
+		note.Set("book", target.Id)
+		if targetPrimary != nil {
+			note.Set("status", cron.StatusPending)
+			note.Set("statusReason", "")
+			note.Set("attempts", 0)
+			note.Set("processed", false)
+			requeued = append(requeued, note.Id)
+		}
+		if err := app.Save(note); err != nil {
+			return result, nil, err
+		}
+		result.Notes++
+	}
+
+	// Club sessions are a schedule of goals, they all carry over
+	bookSessions, err := findByBook(app, "book_sessions", source.Id)
+	if err != nil {
+		return result, nil, err
+	}
+	for _, session := range bookSessions {
+		session.Set("book", target.Id)
+		if err := app.Save(session); err != nil {
+			return result, nil, err
+		}
+		result.BookSessions++
+	}
+
+	// Reader sessions: one per reader, so a reader of both books keeps a combined one
+	readerSessions, err := findByBook(app, "readers_sessions", source.Id)
+	if err != nil {
+		return result, nil, err
+	}
+	for _, session := range readerSessions {
+		existing := sessions.FindReaderSession(app, target.Id, session.GetString("user"))
+
+		if existing == nil {
+			session.Set("book", target.Id)
+			if err := app.Save(session); err != nil {
+				return result, nil, err
+			}
+			result.ReadersSessions++
+			continue
+		}
+
+		combineReaderSessions(existing, session)
+		if err := app.Save(existing); err != nil {
+			return result, nil, err
+		}
+		if err := app.Delete(session); err != nil {
+			return result, nil, err
+		}
+		result.CombinedSessions++
+	}
+
+	// Book details the duplicate knows better
+	for _, field := range mergedBookFields {
+		if target.GetString(field) == "" && source.GetString(field) != "" {
+			target.Set(field, source.Get(field))
+		}
+	}
+	if target.GetInt("totalPages") == 0 {
+		target.Set("totalPages", source.GetInt("totalPages"))
+	}
+	if target.GetString("status") == "planned" {
+		target.Set("status", source.GetString("status"))
+	}
+
+	if target.GetString("cover") == "" && source.GetString("cover") != "" {
+		if err := copyCover(app, target, source); err != nil {
+			return result, nil, err
+		}
+	}
+
+	if err := app.Save(target); err != nil {
+		return result, nil, err
+	}
+	if err := app.Delete(source); err != nil {
+		return result, nil, err
+	}
+
+	return result, requeued, nil
+}
+
+// Helper: Combine a reader's session on the duplicate into their session on the kept book.
+// The furthest progress wins, ratings and reviews are only filled in.
+func combineReaderSessions(kept *core.Record, other *core.Record) {
+	if other.GetInt("currentPage") > kept.GetInt("currentPage") {
+		kept.Set("currentPage", other.GetInt("currentPage"))
+		kept.Set("syncProgress", other.Get("syncProgress"))
+	}
+	if kept.GetInt("bookTotalPages") == 0 {
+		kept.Set("bookTotalPages", other.GetInt("bookTotalPages"))
+	}
+
+	// Finishing the book in either session counts
+	if other.GetString("status") == "completed" {
+		kept.Set("status", "completed")
+		if kept.GetDateTime("ended").IsZero() {
+			kept.Set("ended", other.GetDateTime("ended"))
+		}
+	}
+
+	if kept.GetFloat("rating") == 0 {
+		kept.Set("rating", other.GetFloat("rating"))
+	}
+	if kept.GetString("review") == "" {
+		kept.Set("review", other.GetString("review"))
+	}
+}
+
+// Helper: Copy the cover file and its details from one book to another
+func copyCover(app core.App, target *core.Record, source *core.Record) error {
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return err
+	}
+	defer fsys.Close()
+
+	// Read it now, the filesystem is closed by the time the book is saved
+	name := source.GetString("cover")
+	r, err := fsys.GetReader(source.BaseFilesPath() + "/" + name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	f, err := filesystem.NewFileFromBytes(data, "cover"+filepath.Ext(name))
+	if err != nil {
+		return err
+	}
+
+	target.Set("cover", f)
+	for _, field := range mergedCoverFields {
+		target.Set(field, source.Get(field))
+	}
+	return nil
+}
+
+// Helper: All records of a collection that belong to a book
+func findByBook(app core.App, collection string, bookId string) ([]*core.Record, error) {
+	return app.FindRecordsByFilter(collection, "book = {:bookId}", "", 0, 0, map[string]any{"bookId": bookId})
+}
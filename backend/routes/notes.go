@@ -1,11 +1,15 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
-	"strings"
+	"sort"
 	"time"
 
-	"github.com/PuerkitoBio/goquery"
+	"sheikahslate/cron"
+	"sheikahslate/matcher"
+
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/tools/types"
 )
@@ -14,12 +18,13 @@ func RegisterNotesRoute(app core.App) {
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 
 		// POST /api/import-apple-books - Import Apple Books notes from email webhook
+		// Only accepts requests signed by Mailgun, see requireMailgunSignature
 		se.Router.POST("/notes/import-apple-books", func(e *core.RequestEvent) error {
 			// ---------------------------------------------------------
 			// 1. EXTRACT DATA FROM WEBHOOK
 			// ---------------------------------------------------------
 			htmlContent := e.Request.FormValue("body-html")
-			senderEmail := e.Request.FormValue("sender") // e.g. "dishit79@icloud.com"
+			recipient := e.Request.FormValue("recipient") // e.g. "k3x9...@notes.example.com"
 
 			if htmlContent == "" {
 				return e.BadRequestError("No 'body-html' field found", nil)
@@ -28,129 +33,181 @@ func RegisterNotesRoute(app core.App) {
 			// ---------------------------------------------------------
 			// 2. RESOLVE USER (Required by your 'notes' schema)
 			// ---------------------------------------------------------
-			// We try to find the user in PocketBase by the email sending the webhook
-			userRecord, err := app.FindFirstRecordByFilter("users", "email={:email}", map[string]any{
-				"email": senderEmail,
-			})
-			if err != nil {
-				// Option A: Fail if user not found
-				return e.BadRequestError("Could not find user with email: "+senderEmail, err)
+			// The private address the notes were sent to says who they belong to,
+			// the sender can be any account (or forged), see RegisterInboundAddressRoutes
+			userRecord := findUserByRecipients(app, recipient, e.Request.FormValue("To"))
+			if userRecord == nil {
+				return e.BadRequestError("Unknown inbound address: "+recipient, nil)
 			}
 
 			// ---------------------------------------------------------
-			// 3. PARSE HTML
+			// 3. PARSE HTML AND SAVE THE NOTES
 			// ---------------------------------------------------------
-			doc, err := goquery.NewDocumentFromReader(strings.NewReader(htmlContent))
+			result, err := importAppleBooksEmail(app, userRecord, e.Request.FormValue("subject"), htmlContent, mailgunDate(e))
 			if err != nil {
-				return e.BadRequestError("Failed to parse HTML", err)
-			}
-
-			// Extract Book Title & Author
-			title := strings.TrimSpace(doc.Find(".booktitle").Text())
-			// author := strings.TrimSpace(doc.Find(".booktitle + h2").Text())
-
-			// Fallback if HTML parsing fails (sometimes Apple changes classes)
-			if title == "" {
-				subject := e.Request.FormValue("subject") // "Notes from "The Silent Patient"..."
-				if strings.Contains(subject, `“`) && strings.Contains(subject, `”`) {
-					parts := strings.Split(subject, `“`)
-					if len(parts) > 1 {
-						title = strings.Split(parts[1], `”`)[0]
-					}
+				var emailErr *appleEmailError
+				if errors.As(err, &emailErr) {
+					return e.BadRequestError(emailErr.Message, emailErr.Err)
 				}
+				return e.InternalServerError("Failed to import notes", err)
 			}
 
-			if title == "" {
-				return e.BadRequestError("Could not determine book title", nil)
-			}
-
-			// ---------------------------------------------------------
-			// 4. FIND OR CREATE BOOK
-			// ---------------------------------------------------------
-
-			bookRecord, err := app.FindFirstRecordByFilter("books", "title={:title}", map[string]any{
-				"title": title,
+			return e.JSON(http.StatusOK, map[string]any{
+				"status":      "success",
+				"book":        result.Book.GetString("title"),
+				"bookId":      result.Book.Id,
+				"bookCreated": result.BookCreated,
+				"imported":    result.Imported,
+				"user":        userRecord.GetString("email"),
 			})
-			if err != nil {
+		}).Bind(requireMailgunSignature())
 
-				return e.BadRequestError("Book Does Not Exist", err)
+		// POST /notes/{id}/location - Move a note to one of its alternative locations
+		se.Router.POST("/notes/{id}/location", func(e *core.RequestEvent) error {
+			data := struct {
+				Alternative int `json:"alternative"`
+			}{}
+
+			if err := e.BindBody(&data); err != nil {
+				return e.BadRequestError("Invalid request body", err)
 			}
 
-			// ---------------------------------------------------------
-			// 5. PROCESS NOTES
-			// ---------------------------------------------------------
-			notesCollection, err := app.FindCollectionByNameOrId("notes")
+			note, err := app.FindRecordById("notes", e.Request.PathValue("id"))
 			if err != nil {
-				return e.InternalServerError("Notes collection not found", err)
+				return e.NotFoundError("Note not found", err)
 			}
 
-			var importCount int
-
-			doc.Find(".annotation").Each(func(i int, s *goquery.Selection) {
-				// Scrape the data
-				// Note: Your schema has 'page' as a Number (#).
-				// Apple Books gives "Chapter One" (text).
-				// We cannot safely map Text -> Number, so we skip 'page' for now.
-
-				quote := strings.TrimSpace(s.Find(".annotationrepresentativetext").Text()) // -> bookText
-				note := strings.TrimSpace(s.Find(".annotationnote").Text())                // -> note
+			// Only the owner of a note may move it
+			if note.GetString("user") != e.Auth.Id {
+				return e.ForbiddenError("You can only move your own notes", nil)
+			}
 
-				// Extract raw date string: "December 1, 2025"
-				rawDate := strings.TrimSpace(s.Find(".annotationdate").Text())
+			var alternatives []matcher.Match
+			if err := note.UnmarshalJSONField("alternatives", &alternatives); err != nil || data.Alternative < 0 || data.Alternative >= len(alternatives) {
+				return e.BadRequestError("Unknown alternative location", err)
+			}
 
-				// Skip empty entries
-				if quote == "" && note == "" {
-					return
-				}
+			chosen := alternatives[data.Alternative]
 
-				// Check for duplicates to prevent spamming
-				// Modern syntax: Find records by filter
-				existingRecords, _ := app.FindRecordsByFilter(
-					"notes",
-					"book = {:book} && bookText = {:text} && user = {:user}",
-					"-created",
-					1,
-					0,
-					map[string]any{
-						"book": bookRecord.Id,
-						"text": quote,
-						"user": userRecord.Id,
-					},
-				)
-
-				if len(existingRecords) == 0 {
-					// Create new Note
-					newNote := core.NewRecord(notesCollection)
-
-					newNote.Set("book", bookRecord.Id)
-					newNote.Set("user", userRecord.Id)
-					newNote.Set("bookText", quote) // Mapped to your 'bookText' field
-					newNote.Set("note", note)      // Mapped to your 'note' field
-					newNote.Set("processed", false)
-
-					if rawDate != "" {
-						parsedTime, err := time.Parse("January 2, 2006", rawDate)
-						if err == nil {
-							// Convert standard Go time to PocketBase DateTime type
-							pbDate, _ := types.ParseDateTime(parsedTime)
-							newNote.Set("created", pbDate)
-						}
-					}
-
-					if err := app.Save(newNote); err == nil {
-						importCount++
-					}
+			// The current location becomes one of the alternatives
+			candidates := append(alternatives, matcher.Match{
+				Page:        note.GetInt("page"),
+				EndPage:     note.GetInt("endPage"),
+				StartOffset: note.GetInt("startOffset"),
+				EndOffset:   note.GetInt("endOffset"),
+				Score:       note.GetFloat("matchScore"),
+			})
+			sort.Slice(candidates, func(i, j int) bool {
+				if candidates[i].Page != candidates[j].Page {
+					return candidates[i].Page < candidates[j].Page
 				}
+				return candidates[i].StartOffset < candidates[j].StartOffset
 			})
 
-			return e.JSON(http.StatusOK, map[string]any{
-				"status":   "success",
-				"book":     title,
-				"imported": importCount,
-				"user":     userRecord.GetString("email"),
-			})
-		})
+			cron.SetNoteLocation(note, chosen, candidates)
+			note.Set("status", cron.StatusMatched)
+			note.Set("statusReason", "Location chosen by user")
+
+			if err := app.Save(note); err != nil {
+				return e.InternalServerError("Failed to save note", err)
+			}
+
+			return e.JSON(http.StatusOK, note)
+		}).Bind(apis.RequireAuth())
 
 		return se.Next()
 	})
 }
+
+// Helper: Add a book we only know the title and author of to the planned list
+func createPlannedBook(app core.App, title string, author string) (*core.Record, error) {
+	collection, err := app.FindCollectionByNameOrId("books")
+	if err != nil {
+		return nil, err
+	}
+
+	// The author is required, Apple leaves it out for some books
+	if author == "" {
+		author = "Unknown author"
+	}
+
+	book := core.NewRecord(collection)
+	book.Set("title", title)
+	book.Set("author", author)
+	book.Set("status", "planned")
+
+	if err := app.Save(book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// importedNote is a highlight read from an e-reader or email export
+type importedNote struct {
+	BookText string
+	Note     string
+	Created  time.Time // zero when the export has no date
+	Source   string    // apple_books, kindle, ...
+	Location string    // the reader's own position, e.g. "Location 180-182"
+	Chapter  string    // chapter title when the export has one
+	Style    string    // highlight colour or "underline"
+	Zoned    bool      // Created is in the reader's own time zone, kept as 'originalDate'
+}
+
+// Helper: Create a note for an imported highlight unless the user already has it.
+// Duplicates are matched on the highlighted text, or on the note for note-only entries.
+func saveImportedNote(app core.App, collection *core.Collection, bookId string, userId string, data importedNote) (bool, error) {
+	filter := "book = {:book} && bookText = {:text} && user = {:user}"
+	if data.BookText == "" {
+		filter += " && note = {:note}"
+	}
+
+	// Modern syntax: Find records by filter
+	existingRecords, err := app.FindRecordsByFilter(
+		"notes",
+		filter,
+		"-created",
+		1,
+		0,
+		map[string]any{
+			"book": bookId,
+			"text": data.BookText,
+			"note": data.Note,
+			"user": userId,
+		},
+	)
+	if err != nil {
+		return false, err
+	}
+	if len(existingRecords) > 0 {
+		return false, nil
+	}
+
+	// Create new Note
+	newNote := core.NewRecord(collection)
+	newNote.Set("book", bookId)
+	newNote.Set("user", userId)
+	newNote.Set("bookText", data.BookText)
+	newNote.Set("note", data.Note)
+	newNote.Set("source", data.Source)
+	newNote.Set("location", data.Location)
+	newNote.Set("chapter", data.Chapter)
+	newNote.Set("highlightStyle", data.Style)
+	newNote.Set("processed", false)
+	newNote.Set("status", cron.StatusPending)
+
+	if !data.Created.IsZero() {
+		// Convert standard Go time to PocketBase DateTime type
+		pbDate, _ := types.ParseDateTime(data.Created)
+		newNote.Set("created", pbDate)
+
+		if data.Zoned {
+			newNote.Set("originalDate", data.Created.Format(time.RFC3339))
+		}
+	}
+
+	if err := app.Save(newNote); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,318 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	opdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+)
+
+// How long a checked Basic auth login is trusted before bcrypt runs again
+const opdsLoginTTL = 5 * time.Minute
+
+var (
+	opdsLoginsMu sync.Mutex
+	opdsLogins   = map[string]time.Time{} // hash of user, tokenKey and password -> expiry
+)
+
+// The shelves of the catalog, one acquisition feed per books.status
+var opdsShelves = []struct {
+	Status  string
+	Title   string
+	Summary string
+}{
+	{"reading", "Currently reading", "Books the club is reading right now"},
+	{"planned", "Planned", "Books the club will read next"},
+	{"completed", "Completed", "Books the club has finished"},
+}
+
+type opdsFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr"`
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Links     []opdsLink  `xml:"link"`
+	Entries   []opdsEntry `xml:"entry"`
+}
+
+type opdsEntry struct {
+	Title     string       `xml:"title"`
+	Id        string       `xml:"id"`
+	Updated   string       `xml:"updated"`
+	Authors   []opdsAuthor `xml:"author,omitempty"`
+	Language  string       `xml:"dc:language,omitempty"`
+	Publisher string       `xml:"dc:publisher,omitempty"`
+	Content   *opdsContent `xml:"content,omitempty"`
+	Links     []opdsLink   `xml:"link"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+func RegisterOPDSRoute(app core.App) {
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		// Reading apps only speak HTTP Basic auth, so check the member's email and password
+		opds := se.Router.Group("/opds")
+		opds.BindFunc(func(e *core.RequestEvent) error {
+			email, password, ok := e.Request.BasicAuth()
+			if ok {
+				user, err := app.FindAuthRecordByEmail("users", email)
+				if err == nil && opdsCheckPassword(user, password) {
+					e.Auth = user
+					return e.Next()
+				}
+			}
+
+			e.Response.Header().Set("WWW-Authenticate", `Basic realm="Book club library", charset="UTF-8"`)
+			return e.UnauthorizedError("Log in with your book club email and password", nil)
+		})
+
+		// GET /opds - Root navigation feed with one entry per shelf
+		opds.GET("", func(e *core.RequestEvent) error {
+			now := opdsTime(time.Now())
+			feed := newOPDSFeed("urn:bookclub:opds", "Book club library", now, "/opds", opdsNavigationType)
+
+			for _, shelf := range opdsShelves {
+				feed.Entries = append(feed.Entries, opdsEntry{
+					Title:   shelf.Title,
+					Id:      "urn:bookclub:opds:" + shelf.Status,
+					Updated: now,
+					Content: &opdsContent{Type: "text", Text: shelf.Summary},
+					Links:   []opdsLink{{Rel: "subsection", Href: "/opds/" + shelf.Status, Type: opdsAcquisitionType}},
+				})
+			}
+			feed.Entries = append(feed.Entries, opdsEntry{
+				Title:   "All books",
+				Id:      "urn:bookclub:opds:all",
+				Updated: now,
+				Content: &opdsContent{Type: "text", Text: "Every book in the club library"},
+				Links:   []opdsLink{{Rel: "subsection", Href: "/opds/all", Type: opdsAcquisitionType}},
+			})
+
+			return writeOPDSFeed(e, feed, opdsNavigationType)
+		})
+
+		// GET /opds/{shelf} - Acquisition feed of the books on a shelf, or "all"
+		opds.GET("/{shelf}", func(e *core.RequestEvent) error {
+			shelf := e.Request.PathValue("shelf")
+			title := "All books"
+			filter := "id != ''"
+			if shelf != "all" {
+				title = ""
+				for _, s := range opdsShelves {
+					if s.Status == shelf {
+						title = s.Title
+					}
+				}
+				if title == "" {
+					return e.NotFoundError("Unknown shelf", nil)
+				}
+				filter = "status = {:status}"
+			}
+
+			// 1. Load the books and their primary files
+			books, err := app.FindRecordsByFilter("books", filter, "title", 0, 0, map[string]any{"status": shelf})
+			if err != nil {
+				return e.InternalServerError("Failed to load books", err)
+			}
+
+			files, err := app.FindRecordsByFilter("files", "primaryFile = true", "", 0, 0)
+			if err != nil {
+				return e.InternalServerError("Failed to load files", err)
+			}
+			primaryFiles := map[string]*core.Record{}
+			for _, file := range files {
+				primaryFiles[file.GetString("book")] = file
+			}
+
+			// 2. One entry per book
+			feed := newOPDSFeed("urn:bookclub:opds:"+shelf, title, opdsTime(time.Now()), "/opds/"+shelf, opdsAcquisitionType)
+			for _, book := range books {
+				feed.Entries = append(feed.Entries, opdsBookEntry(book, primaryFiles[book.Id]))
+			}
+
+			return writeOPDSFeed(e, feed, opdsAcquisitionType)
+		})
+
+		// GET /opds/download/{id} - Book file of a files record, behind the catalog's login
+		opds.GET("/download/{id}", func(e *core.RequestEvent) error {
+			file, err := app.FindRecordById("files", e.Request.PathValue("id"))
+			if err != nil {
+				return e.NotFoundError("File not found", err)
+			}
+			filename := file.GetString("filename")
+			if filename == "" {
+				return e.NotFoundError("File not found", nil)
+			}
+
+			fsys, err := app.NewFilesystem()
+			if err != nil {
+				return e.InternalServerError("Failed to open file storage", err)
+			}
+			defer fsys.Close()
+
+			e.Response.Header().Set("Content-Type", opdsFileType(file.GetString("filetype"), filename))
+			e.Response.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+			if err := fsys.Serve(e.Response, e.Request, file.BaseFilesPath()+"/"+filename, filename); err != nil {
+				return e.NotFoundError("File not found", err)
+			}
+			return nil
+		})
+
+		return se.Next()
+	})
+}
+
+// Helper: Check a Basic auth password. Reading apps send it with every request,
+// so a successful bcrypt check is remembered for a while. Changing the password
+// rotates the user's tokenKey, which ends the remembered login.
+func opdsCheckPassword(user *core.Record, password string) bool {
+	sum := sha256.Sum256([]byte(user.Id + "\x00" + user.TokenKey() + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+
+	opdsLoginsMu.Lock()
+	expires, ok := opdsLogins[key]
+	if ok && time.Now().After(expires) {
+		delete(opdsLogins, key)
+		ok = false
+	}
+	opdsLoginsMu.Unlock()
+	if ok {
+		return true
+	}
+
+	if !user.ValidatePassword(password) {
+		return false
+	}
+
+	opdsLoginsMu.Lock()
+	opdsLogins[key] = time.Now().Add(opdsLoginTTL)
+	opdsLoginsMu.Unlock()
+	return true
+}
+
+// Helper: Feed with the self/start/up links every catalog page carries
+func newOPDSFeed(id string, title string, updated string, self string, selfType string) opdsFeed {
+	return opdsFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		Id:        id,
+		Title:     title,
+		Updated:   updated,
+		Links: []opdsLink{
+			{Rel: "self", Href: self, Type: selfType},
+			{Rel: "start", Href: "/opds", Type: opdsNavigationType},
+			{Rel: "up", Href: "/opds", Type: opdsNavigationType},
+		},
+	}
+}
+
+// Helper: Catalog entry for a book with its cover and download link.
+// Books without a primary file are still listed so members can see the shelf.
+// Downloads go through /opds/download so reading apps send their login along.
+func opdsBookEntry(book *core.Record, file *core.Record) opdsEntry {
+	entry := opdsEntry{
+		Title:   book.GetString("title"),
+		Id:      "urn:bookclub:book:" + book.Id,
+		Updated: opdsTime(book.GetDateTime("created").Time()),
+	}
+
+	if author := book.GetString("author"); author != "" {
+		entry.Authors = []opdsAuthor{{Name: author}}
+	}
+
+	// Cover: the uploaded image, else the URL it came from
+	coverUrl := book.GetString("coverImageUrl")
+	if cover := book.GetString("cover"); cover != "" {
+		coverUrl = "/api/files/" + book.BaseFilesPath() + "/" + url.PathEscape(cover)
+	}
+	if coverUrl != "" {
+		coverType := mime.TypeByExtension(filepath.Ext(coverUrl))
+		entry.Links = append(entry.Links,
+			opdsLink{Rel: "http://opds-spec.org/image", Href: coverUrl, Type: coverType},
+			opdsLink{Rel: "http://opds-spec.org/image/thumbnail", Href: coverUrl, Type: coverType},
+		)
+	}
+
+	if file == nil {
+		return entry
+	}
+
+	metadata := map[string]string{}
+	_ = file.UnmarshalJSONField("metadata", &metadata)
+	entry.Language = metadata["language"]
+	entry.Publisher = metadata["publisher"]
+	if description := metadata["description"]; description != "" {
+		entry.Content = &opdsContent{Type: "text", Text: description}
+	}
+
+	filename := file.GetString("filename")
+	entry.Links = append(entry.Links, opdsLink{
+		Rel:   "http://opds-spec.org/acquisition",
+		Href:  "/opds/download/" + url.PathEscape(file.Id),
+		Type:  opdsFileType(file.GetString("filetype"), filename),
+		Title: "Download",
+	})
+
+	return entry
+}
+
+// Helper: Media type of a book file for reading apps
+func opdsFileType(filetype string, filename string) string {
+	switch filetype {
+	case "pdf":
+		return "application/pdf"
+	case "epub":
+		return "application/epub+zip"
+	}
+	if t := mime.TypeByExtension(filepath.Ext(filename)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+func opdsTime(t time.Time) string {
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Helper: Serialize a feed with the OPDS media type
+func writeOPDSFeed(e *core.RequestEvent, feed opdsFeed, contentType string) error {
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return e.InternalServerError("Failed to render catalog", err)
+	}
+
+	return e.Blob(http.StatusOK, contentType+";charset=utf-8", append([]byte(xml.Header), body...))
+}
@@ -1,13 +1,12 @@
 package routes
 
 import (
-	"fmt"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 
-	"github.com/ledongthuc/pdf"
+	"sheikahslate/bookfile"
+
 	"github.com/pocketbase/pocketbase/core"
 )
 
@@ -25,75 +24,52 @@ func RegisterPDFRoute(app core.App) {
 
 			// 2. Find the Primary File for this Book
 			// We query the 'files' collection where 'book' matches and 'primaryFile' is true
-			record, err := app.FindFirstRecordByFilter(
-				"files",
-				"book = {:bookId} && primaryFile = true",
-				map[string]any{"bookId": bookId},
-			)
-
+			record, err := bookfile.FindPrimaryFile(app, bookId)
 			if err != nil {
 				return e.NotFoundError("Book file not found", err)
 			}
 
-			// 3. Construct the Filesystem Path
-			// PocketBase stores files in: /pb_data/storage/{collectionId}/{recordId}/{filename}
-			filename := record.GetString("filename")
-			collectionId := record.Collection().Id
-			recordId := record.Id
-
-			// Get the data directory path
-			filePath := filepath.Join(app.DataDir(), "storage", collectionId, recordId, filename)
+			// 3. Load the extracted text (PDF or EPUB), parsed once and cached per file
+			doc, err := bookfile.Load(app, record)
+			if err != nil {
+				return e.InternalServerError("Failed to extract book content", err)
+			}
 
-			// 4. Extract Text from PDF (simplified for now - you'll need to add PDF library)
-			content, err := extractPageText(filePath, pageIndex)
+			// 4. Pick the requested page
+			content, err := doc.Page(pageIndex)
 			if err != nil {
 				// If page is out of bounds, return empty content or specific error
-				return e.InternalServerError("Failed to extract PDF content", err)
+				return e.InternalServerError("Failed to extract book content", err)
 			}
 
 			// 5. Return JSON in the format your frontend expects (array of strings/paragraphs)
 			// We split by newline to simulate paragraphs
-			return e.JSON(http.StatusOK, map[string]any{
+			paragraphs := splitIntoParagraphs(content)
+			response := map[string]any{
 				"page":       pageIndex,
-				"content":    splitIntoParagraphs(content),
+				"content":    paragraphs,
 				"contentRaw": content,
-			})
-		})
-
-		return se.Next()
-	})
-}
-
-// Helper: Extract text from a specific page using ledongthuc/pdf
-func extractPageText(path string, targetPage int) (string, error) {
-	f, r, err := pdf.Open(path)
-	if err != nil {
-		return "", err
-	}
-	defer f.Close()
-
-	totalPage := r.NumPage()
-	if targetPage > totalPage {
-		return "", fmt.Errorf("page %d exceeds total pages (%d)", targetPage, totalPage)
-	}
-
-	// ledongthuc/pdf reader allows reading specific pages
-	// Note: It's 1-indexed in the GetPage function usually, but let's check reader.
-	// Reader.Page(i) returns the page.
-	p := r.Page(targetPage)
+			}
 
-	if p.V.IsNull() {
-		return "", nil
-	}
+			// 6. Optionally include the club's highlights on this page (?highlights=true)
+			if includeHighlights, _ := strconv.ParseBool(e.Request.URL.Query().Get("highlights")); includeHighlights {
+				// Notes are only visible to signed in members
+				if e.Auth == nil {
+					return e.UnauthorizedError("Sign in to see highlights", nil)
+				}
 
-	text, err := p.GetPlainText(nil)
-	if err != nil {
-		return "", err
-	}
+				highlights, err := findPageHighlights(app, bookId, pageIndex, content, paragraphs)
+				if err != nil {
+					return e.InternalServerError("Failed to load highlights", err)
+				}
+				response["highlights"] = highlights
+			}
 
-	cleanText := strings.ReplaceAll(text, "\t", " ")
+			return e.JSON(http.StatusOK, response)
+		})
 
-	return cleanText, nil
+		return se.Next()
+	})
 }
 
 // Helper: Split raw text into "paragraphs" for the UI
@@ -0,0 +1,26 @@
+package sessions
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// FindReaderSession returns a user's reading session for a book, preferring
+// the most recent one, or nil if they have none
+func FindReaderSession(app core.App, bookId string, userId string) *core.Record {
+	if userId == "" {
+		return nil
+	}
+
+	records, err := app.FindRecordsByFilter(
+		"readers_sessions",
+		"book = {:bookId} && user = {:userId}",
+		"-joined",
+		1,
+		0,
+		map[string]any{"bookId": bookId, "userId": userId},
+	)
+	if err != nil || len(records) == 0 {
+		return nil
+	}
+	return records[0]
+}